@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+type AlignmentKind int
+
+const (
+	AlignmentKindDeclaration AlignmentKind = iota
+	AlignmentKindBitfield
+	AlignmentKindAssignment
+	AlignmentKindMacroValue
+	AlignmentKindEnumValue
+	AlignmentKindTrailingComment
+	AlignmentKindCount
+)
+
+// An AlignmentPoint is a position in the output that can be moved right, by inserting
+// spaces at Offset, to line it up with the points of the same kind on the lines above
+// and below it. End is the column its line ends at, and Wraps tells whether the line
+// is continued on the next one by the statement it is in.
+type AlignmentPoint struct {
+	Kind   AlignmentKind
+	Scope  int
+	Line   int
+	Column int
+	Offset int
+	End    int
+	Wraps  bool
+}
+
+type Padding struct {
+	Offset int
+	Spaces int
+}
+
+func (f *Formatter) isStatementScope() bool {
+	switch f.Node().Type {
+	case NodeTypeTopLevel,
+		NodeTypeLinkageSpecification,
+		NodeTypeBlock,
+		NodeTypeSwitch,
+		NodeTypeImplicitBlock,
+		NodeTypeStructOrUnion:
+		return f.OpenParenthesis == f.Node().InitialParenthesis
+	default:
+		return false
+	}
+}
+
+func (f *Formatter) isStatementStart() bool {
+	// Inline comments, as in /*static*/ int x, are skipped
+	index := f.TokenIndex - 1
+
+	for f.isInlineComment(index) {
+		index--
+	}
+
+	previous := f.tokenAt(index)
+
+	return f.isStatementScope() &&
+		(previous.isAbsent() || previous.isSemicolon() || previous.isLeftBrace() || previous.isRightBrace() ||
+			previous.isComment() || f.isImplicitBlockStart() ||
+			(f.LastPop.isDirective() && f.LastPop.LastToken == index))
+}
+
+// Returns the indices of the first token of the declarator of a variable declaration
+// starting at index and of its name, or -1 if the statement is not one. Names are
+// aligned, with the * of right aligned pointers hanging before them. With left aligned
+// pointers, the declarator begins with its name.
+func (f *Formatter) declarator(index int) (int, int) {
+	i := index
+	hasType := false
+
+	for {
+		token := f.tokenAt(i)
+
+		if token.isTypeKeyword() {
+			hasType = true
+			i++
+		} else if token.isDeclarationSpecifier() {
+			i++
+		} else if (token.isStructOrUnion() || token.isEnum()) && f.tokenAt(i+1).isIdentifier() {
+			hasType = true
+			i += 2
+		} else if token.isIdentifier() && !hasType {
+			hasType = true
+			i++
+		} else {
+			break
+		}
+	}
+
+	if !hasType {
+		return -1, -1
+	}
+
+	start := i
+
+	for f.tokenAt(i).canBePointerOperator() || f.tokenAt(i).isTypeQualifier() {
+		i++
+	}
+
+	name := f.tokenAt(i)
+	next := f.tokenAt(i + 1)
+
+	if !name.isIdentifier() ||
+		!(next.isPlainAssignment() || next.isSemicolon() || next.isLeftBracket() || next.isComma() ||
+			(next.isColon() && f.Node().isStructOrUnion())) {
+		return -1, -1
+	}
+
+	if f.Options.PointerAlignment == PointerAlignmentLeft {
+		return i, i
+	}
+
+	return start, i
+}
+
+func (f *Formatter) nextDeclarator() int {
+	start, _ := f.declarator(f.TokenIndex + 1)
+	return start
+}
+
+// Whether the : at index separates a member of a struct or union from its bit width
+func (f *Formatter) isBitfieldColon(index int) bool {
+	return f.Node().isStructOrUnion() &&
+		f.tokenAt(index).isColon() &&
+		f.DeclaratorName == index-1
+}
+
+// Whether the current token is a comment written after code on the same line
+func (f *Formatter) isTrailingComment() bool {
+	lineStart := bytes.LastIndexByte(f.Output, '\n') + 1
+
+	return f.token().isComment() &&
+		len(bytes.TrimSpace(f.Output[lineStart:])) > 0
+}
+
+func (f *Formatter) alignsDeclarations() bool {
+	return f.Options.AlignDeclarations || (f.Options.AlignStructMembers && f.Node().isStructOrUnion())
+}
+
+// Whether the current token is the first token of the value of a #define
+func (f *Formatter) isMacroValueStart() bool {
+	// The directive is already popped when the value is its last token
+	if f.Node().DirectiveType != DirectiveTypeDefine &&
+		!(f.LastPop.DirectiveType == DirectiveTypeDefine && f.LastPop.LastToken == f.TokenIndex) {
+		return false
+	}
+
+	name := f.TokenIndex - 1
+
+	if f.previousToken().isRightParenthesis() {
+		for name >= 0 && !f.tokenAt(name).isLeftParenthesis() {
+			name--
+		}
+
+		name--
+
+		// Parameters follow the name of a function-like macro without a space
+		if f.tokenAt(name).Whitespace.HasSpace {
+			return false
+		}
+	}
+
+	return f.tokenAt(name).isIdentifier() &&
+		f.tokenAt(name-1).isDefine() &&
+		!f.tokenAt(f.TokenIndex-1).hasNewLines() &&
+		(name != f.TokenIndex-1 || !f.token().isLeftParenthesis() || f.previousToken().Whitespace.HasSpace)
+}
+
+func (f *Formatter) addAlignmentPoint(kind AlignmentKind) {
+	scope := len(f.Nodes) - 1
+
+	// Directives are aligned with the others of the scope they are in
+	for scope > 0 && f.Nodes[scope].isDirective() {
+		scope--
+	}
+
+	f.AlignmentPoints = append(f.AlignmentPoints, AlignmentPoint{
+		Kind:   kind,
+		Scope:  f.Nodes[scope].Id,
+		Line:   f.OutputLine,
+		Column: f.OutputColumn,
+		Offset: len(f.Output),
+	})
+}
+
+// Records the alignment points of the current token, before it is written
+func (f *Formatter) updateAlignment() {
+	if f.isStatementStart() {
+		f.Declarator, f.DeclaratorName = f.declarator(f.TokenIndex)
+	}
+
+	if f.alignsDeclarations() && f.TokenIndex == f.Declarator {
+		f.addAlignmentPoint(AlignmentKindDeclaration)
+	}
+
+	if f.alignsDeclarations() && f.TokenIndex == f.DeclaratorName && f.Declarator != f.DeclaratorName {
+		f.AlignmentPoints[len(f.AlignmentPoints)-1].Column = f.OutputColumn
+	}
+
+	if f.Options.AlignStructMembers && f.isBitfieldColon(f.TokenIndex) {
+		f.addAlignmentPoint(AlignmentKindBitfield)
+	}
+
+	if f.isTrailingComment() && f.token().isSingleLineComment() &&
+		(f.Options.AlignTrailingComments || (f.Options.AlignStructMembers && f.Node().isStructOrUnion())) {
+		f.addAlignmentPoint(AlignmentKindTrailingComment)
+	}
+
+	if f.Options.AlignAssignments && f.token().isAssignment() && !f.AssignmentAligned &&
+		f.isStatementScope() && !f.Node().isStructOrUnion() {
+		f.addAlignmentPoint(AlignmentKindAssignment)
+		f.AssignmentAligned = true
+
+		// The = of compound assignments is aligned with the others
+		f.AlignmentPoints[len(f.AlignmentPoints)-1].Column += len(f.token().Content) - 1
+	}
+
+	if f.Options.AlignMacros && f.isMacroValueStart() {
+		f.addAlignmentPoint(AlignmentKindMacroValue)
+	}
+
+	if f.Options.AlignEnumValues && f.isEnumValueAssignment() {
+		f.addAlignmentPoint(AlignmentKindEnumValue)
+	}
+
+	if f.token().isSemicolon() || f.token().isLeftBrace() || f.token().isRightBrace() {
+		f.AssignmentAligned = false
+	}
+}
+
+// Records the column the current line ends at in its alignment points, before a new
+// line is written
+func (f *Formatter) endAlignmentLine() {
+	for i := len(f.AlignmentPoints) - 1; i >= 0 && f.AlignmentPoints[i].Line == f.OutputLine; i-- {
+		f.AlignmentPoints[i].End = f.OutputColumn
+	}
+}
+
+// Marks the alignment points of the current line as wrapped, before the statement
+// continues on the next line
+func (f *Formatter) wrapAlignmentLine() {
+	for i := len(f.AlignmentPoints) - 1; i >= 0 && f.AlignmentPoints[i].Line == f.OutputLine; i-- {
+		f.AlignmentPoints[i].Wraps = true
+	}
+}
+
+// Whether lines that are aligned with each other are kept on consecutive lines at top
+// level, where statements and directives are otherwise separated by a blank line
+func (f *Formatter) continuesAlignedRun() bool {
+	if f.token().Whitespace.NewLines != 1 || !f.Node().isDeclarationScope() {
+		return false
+	}
+
+	if f.isEndOfDirective() {
+		return f.Options.AlignMacros &&
+			f.LastPop.DirectiveType == DirectiveTypeDefine &&
+			f.nextToken().DirectiveType == DirectiveTypeDefine
+	}
+
+	return (f.Options.AlignDeclarations || f.Options.AlignAssignments) &&
+		f.token().isSemicolon() &&
+		f.OpenParenthesis == f.Node().InitialParenthesis &&
+		f.Declarator >= 0 &&
+		f.nextDeclarator() >= 0
+}
+
+// Computes the spaces that line up each run of points of the same kind and scope on
+// consecutive lines. Kinds are aligned in order, so the padding added for a kind moves
+// the points of later kinds on the same line, and the end of the line. Points whose
+// line would end past the column limit are left out of their run, starting from the
+// rightmost, and points on lines that wrap are never aligned.
+func alignmentPadding(points []AlignmentPoint, columnLimit int) []Padding {
+	points = slices.Clone(points)
+	result := []Padding{}
+
+	for kind := AlignmentKind(0); kind < AlignmentKindCount; kind++ {
+		run := []int{}
+
+		flush := func() {
+			for len(run) > 1 {
+				column := 0
+				rightmost := 0
+
+				for j, i := range run {
+					if points[i].Column > column {
+						column = points[i].Column
+						rightmost = j
+					}
+				}
+
+				fits := true
+
+				for _, i := range run {
+					end := max(points[i].End, points[i].Column)
+
+					if columnLimit > 0 && end+column-points[i].Column > columnLimit {
+						fits = false
+					}
+				}
+
+				if !fits {
+					run = slices.Delete(run, rightmost, rightmost+1)
+					continue
+				}
+
+				for _, i := range run {
+					spaces := column - points[i].Column
+
+					if spaces == 0 {
+						continue
+					}
+
+					result = append(result, Padding{points[i].Offset, spaces})
+
+					for j := range points {
+						if points[j].Line != points[i].Line {
+							continue
+						}
+
+						points[j].End += spaces
+
+						if points[j].Offset >= points[i].Offset && j != i {
+							points[j].Column += spaces
+						}
+					}
+				}
+
+				break
+			}
+
+			run = run[:0]
+		}
+
+		for i, point := range points {
+			if point.Kind != kind || point.Wraps {
+				continue
+			}
+
+			if len(run) > 0 {
+				last := points[run[len(run)-1]]
+
+				if last.Line == point.Line {
+					continue
+				}
+
+				if last.Line+1 != point.Line || last.Scope != point.Scope {
+					flush()
+				}
+			}
+
+			run = append(run, i)
+		}
+
+		flush()
+	}
+
+	return result
+}
+
+func insertPadding(output []byte, padding []Padding) []byte {
+	slices.SortStableFunc(padding, func(a Padding, b Padding) int {
+		return a.Offset - b.Offset
+	})
+
+	var result strings.Builder
+	start := 0
+
+	for _, p := range padding {
+		result.Write(output[start:p.Offset])
+		result.WriteString(strings.Repeat(" ", p.Spaces))
+		start = p.Offset
+	}
+
+	result.Write(output[start:])
+
+	return []byte(result.String())
+}
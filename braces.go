@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+type BraceStyle int
+
+const (
+	BraceStyleAttach BraceStyle = iota
+	BraceStyleAllman
+	BraceStyleGnu
+)
+
+type BraceStyleName struct {
+	Name       string
+	BraceStyle BraceStyle
+}
+
+var braceStyleNames = [...]BraceStyleName{
+	{"attach", BraceStyleAttach},
+	{"allman", BraceStyleAllman},
+	{"gnu", BraceStyleGnu},
+}
+
+func parseBraceStyle(name string) (BraceStyle, error) {
+	for _, n := range braceStyleNames {
+		if n.Name == name {
+			return n.BraceStyle, nil
+		}
+	}
+
+	return BraceStyleAttach, fmt.Errorf("invalid brace style: %s", name)
+}
+
+func (b BraceStyle) String() string {
+	for _, n := range braceStyleNames {
+		if n.BraceStyle == b {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected brace style %d", b))
+}
+
+func (f *Formatter) endsFuncOrMacroDef() bool {
+	return f.LastPop.isFuncOrMacroDef() && f.LastPop.LastToken == f.TokenIndex
+}
+
+// Must be called on the token before a left brace, before the brace is read. Initializer
+// lists are only broken before the brace when they are laid out on several lines.
+func (f *Formatter) nextBraceStyle() BraceStyle {
+	if !f.nextToken().isLeftBrace() || f.Node().isDirective() {
+		return BraceStyleAttach
+	}
+
+	switch {
+	case f.token().isAssignment():
+		if f.breaksGroup(f.TokenIndex + 1) {
+			return f.Options.InitializerBraces
+		}
+		return BraceStyleAttach
+	case f.Node().isInitializerList():
+		return BraceStyleAttach
+	case f.AcceptStructOrUnion || f.Node().isStructOrUnion() || f.AcceptEnum:
+		return f.Options.TypeBraces
+	case f.endsFuncOrMacroDef():
+		return f.Options.FunctionBraces
+	case f.isControlStatementHeaderEnd() ||
+		(f.token().isRightParenthesis() && f.parenthesisOwner(f.TokenIndex).isSwitch()):
+		return f.Options.ControlBraces
+	default:
+		return BraceStyleAttach
+	}
+}
+
+func (f *Formatter) writeBeforeBrace() {
+	if f.NextBraceStyle == BraceStyleGnu {
+		f.Indent++
+		f.writeNewLines(1)
+		f.Indent--
+	} else {
+		f.writeNewLines(1)
+	}
+}
@@ -6,10 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s [-stdout] path1 [path2 ...]\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "Usage: %s [-stdout | -check-header-guards] [-style name] [options] path1 [path2 ...]\n", filepath.Base(os.Args[0]))
 	flag.PrintDefaults()
 }
 
@@ -17,22 +18,88 @@ func printError(err error) {
 	_, _ = fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 }
 
-func formatFile(path string, stdout bool) {
+// Warnings already printed, configuration files apply to many source files but their
+// warnings are only printed once
+var printedWarnings sync.Map
+
+func printWarnings(warnings []error) {
+	for _, warning := range warnings {
+		_, printed := printedWarnings.LoadOrStore(warning.Error(), true)
+
+		if !printed {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
+}
+
+// Settings from the command line are applied after those from the closest
+// configuration file, or from .editorconfig files if there is none
+func fileOptions(path string, settings []Setting) (Options, error) {
+	config := findConfig(path)
+	configSettings := []Setting{}
+	warnings := []error{}
+
+	if config != "" {
+		var err error
+		configSettings, warnings, err = readConfig(config)
+
+		if err != nil {
+			return Options{}, err
+		}
+	} else {
+		properties, err := editorConfigProperties(path)
+
+		if err != nil {
+			return Options{}, err
+		}
+
+		configSettings, warnings = editorConfigSettings(properties)
+	}
+
+	printWarnings(warnings)
+	settings = append(configSettings, settings...)
+
+	return resolveOptions(settings)
+}
+
+// Formats a file, or prints it with -stdout. Lines that go past the column limit are
+// reported, and with strict-width the file is left as it is. Returns false if the file
+// could not be formatted.
+func formatFile(path string, stdout bool, settings []Setting) bool {
+
+	options, err := fileOptions(path, settings)
+
+	if err != nil {
+		printError(err)
+		return false
+	}
 
 	data, err := os.ReadFile(path)
 
 	if err != nil {
 		printError(err)
-		return
+		return false
 	}
 
 	text := string(data)
 
-	formattedText, err := Format(text)
+	formattedText, warnings, err := formatWithWarnings(text, path, options)
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s:%s\n", path, err)
-		return
+		return false
+	}
+
+	for _, warning := range warnings {
+		if options.StrictWidth {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, warning)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s:%s\n", path, warning)
+		}
+	}
+
+	if options.StrictWidth && len(warnings) > 0 {
+		return false
 	}
 
 	fmt.Println(path)
@@ -43,14 +110,71 @@ func formatFile(path string, stdout bool) {
 
 		if err != nil {
 			printError(err)
+			return false
 		}
 	}
+
+	return true
+}
+
+// Reports, without formatting, whether the include guard of a header follows the
+// header-guard options. Returns false if it does not.
+func checkFile(path string, settings []Setting) bool {
+	options, err := fileOptions(path, settings)
+
+	if err != nil {
+		printError(err)
+		return false
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		printError(err)
+		return false
+	}
+
+	err = checkHeaderGuard(string(data), path, options)
+
+	if err != nil {
+		fmt.Printf("%s:%s\n", path, err)
+		return false
+	}
+
+	return true
 }
 
 func main() {
 
 	var stdout bool = false
+	var checkHeaderGuards bool = false
+	settings := []Setting{}
+
 	flag.BoolVar(&stdout, "stdout", false, "print to standard output instead of overwriting files")
+	flag.BoolVar(&checkHeaderGuards, "check-header-guards", false, "report headers with a missing or misnamed include guard, without formatting")
+
+	flag.Func("style", "preset to start from: cfmt, linux, llvm, gnu or webkit", func(value string) error {
+		_, err := presetOptions(value)
+		settings = append(settings, Setting{Name: "style", Value: value})
+		return err
+	})
+
+	for _, definition := range optionDefinitions {
+		definition := definition
+
+		set := func(value string) error {
+			setting := Setting{Name: definition.Name, Value: value}
+			settings = append(settings, setting)
+			return applySetting(new(Options), setting)
+		}
+
+		if definition.IsBool {
+			flag.BoolFunc(definition.Name, definition.Usage, set)
+		} else {
+			flag.Func(definition.Name, definition.Usage, set)
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -77,6 +201,7 @@ func main() {
 	}
 
 	wg := sync.WaitGroup{}
+	failed := atomic.Bool{}
 
 	for _, path := range paths {
 		path := path
@@ -85,10 +210,19 @@ func main() {
 
 		go func() {
 			defer wg.Done()
-			formatFile(path, stdout)
+
+			if checkHeaderGuards {
+				failed.CompareAndSwap(false, !checkFile(path, settings))
+			} else {
+				failed.CompareAndSwap(false, !formatFile(path, stdout, settings))
+			}
 		}()
 
 	}
 
 	wg.Wait()
+
+	if failed.Load() {
+		os.Exit(1)
+	}
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	//"fmt"
 )
@@ -19,7 +22,15 @@ func _testTokenizeSingleToken(t *testing.T, text string, tType TokenType) {
 }
 
 func _testFormat(t *testing.T, input string, expected string) {
-	output, _ := Format(input)
+	_testFormatWithOptions(t, input, expected, defaultOptions())
+}
+
+func _testFormatWithOptions(t *testing.T, input string, expected string, options Options) {
+	_testFormatWithPath(t, input, "", expected, options)
+}
+
+func _testFormatWithPath(t *testing.T, input string, path string, expected string, options Options) {
+	output, _ := FormatWithPath(input, path, options)
 
 	for i, r := range []byte(expected) {
 		if i >= len(output) {
@@ -670,7 +681,7 @@ func TestFormatSingleLineComment(t *testing.T) {
 
 func TestFormatMultilineLineComment(t *testing.T) {
 	input := "/*comment*/"
-	expected := "/*\n   comment\n*/\n"
+	expected := "/*comment*/\n"
 	_testFormat(t, input, expected)
 
 	input = "/*\n\ncomment\n\n*/"
@@ -1158,11 +1169,11 @@ func TestFormatColon(t *testing.T) {
         case 0: {
             return 0;
         }
-        break;
+            break;
         case 1: {
             return 2;
         }
-        break;
+            break;
         default: {
             return 86;
         }
@@ -1234,6 +1245,27 @@ func TestFormatPointer(t *testing.T) {
 }
 `
 	_testFormat(t, input, expected)
+
+	input = `typedef struct {
+    PyObject_HEAD
+    PyObject *start;
+} Range;
+
+void f(void) {
+    return
+        a * b;
+}
+`
+	expected = `typedef struct {
+    PyObject_HEAD PyObject *start;
+} Range;
+
+void f(void) {
+    return a * b;
+}
+`
+	_testFormat(t, input, expected)
+	_testFormat(t, expected, expected)
 }
 
 func TestFunctionDecl(t *testing.T) {
@@ -1393,19 +1425,26 @@ func TestFormatWrapping(t *testing.T) {
 
 	input = "{state->load_button.is_mouse_over = state->mouse_position.x >= load_button.x && state->mouse_position.x <= load_button.x + load_button.width && state->mouse_position.y >= load_button.y && state->mouse_position.y <= load_button.y + load_button.height;}"
 	expected = `{
-    state->load_button.is_mouse_over = state->mouse_position.x >= load_button.x && state->mouse_position.x <= load_button.x + load_button.width && state->mouse_position.y >= load_button.y && state->mouse_position.y <= load_button.y + load_button.height;
+    state->load_button.is_mouse_over = state->mouse_position.x >= load_button.x
+        && state->mouse_position.x <= load_button.x + load_button.width
+        && state->mouse_position.y >= load_button.y
+        && state->mouse_position.y <= load_button.y + load_button.height;
 }
 `
 	_testFormat(t, input, expected)
 
 	input = "int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;"
-	expected = "int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;\n"
+	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3
+    + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2
+    + 3 + 4 + 5;
+`
 	_testFormat(t, input, expected)
 
 	input = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 
 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;`
-	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 +
-    2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;
+	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3
+    + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2
+    + 3 + 4 + 5;
 `
 	_testFormat(t, input, expected)
 
@@ -1413,8 +1452,9 @@ func TestFormatWrapping(t *testing.T) {
 	+ 1 + 2 + 3 + 4 + 5+ 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5+ 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;
    
 	typedef struct { struct {C8_Key kp_0;} keypad;} C8_Keypad;`
-	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5
-    + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;
+	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3
+    + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2
+    + 3 + 4 + 5;
 
 typedef struct {
     struct {
@@ -1451,8 +1491,9 @@ void c8_glyph(C8_State *state, C8_Glyph glyph, float x, float y, float width, fl
 	state, x, y + height, rgb.r, rgb.g, rgb.b, rgb.a, glyph.u_left, glyph.v_bottom);
 }`
 
-	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5
-    + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5;
+	expected = `int i = 1 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3
+    + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2 + 3 + 4 + 5 + 2 + 3 + 4 + 5 + 1 + 2
+    + 3 + 4 + 5;
 
 void c8_glyph(C8_State *state, C8_Glyph glyph, float x, float y, float width, float height, C8_Rgba rgb) {
     state->load_button.is_mouse_over = state->mouse_position.x >= load_button.x
@@ -1565,6 +1606,167 @@ void stb_c_lexer_get_location(const stb_lexer *lexer, const char *where, stb_lex
 `
 	_testFormat(t, input, expected)
 
+	input = `void f(void) {
+    size_t const frameHeaderSize = ZSTD_frameHeaderSize_internal(ip, ZSTD_FRAMEHEADERSIZE_PREFIX(dctx->format), dctx->format);
+    result = compute_something(first_argument_value, second_argument_value, nested_call(inner_one, inner_two, inner_3));
+}`
+	expected = `void f(void) {
+    size_t const frameHeaderSize =
+        ZSTD_frameHeaderSize_internal(ip, ZSTD_FRAMEHEADERSIZE_PREFIX(dctx->format), dctx->format);
+    result = compute_something(
+        first_argument_value,
+        second_argument_value,
+        nested_call(inner_one, inner_two, inner_3)
+    );
+}
+`
+	_testFormat(t, input, expected)
+
+	input = `void f(void) {
+    size_t const frameHeaderSize = ZSTD_frameHeaderSize_internal(
+        ip,
+        ZSTD_FRAMEHEADERSIZE_PREFIX(
+            dctx->format
+        ),
+        dctx->format
+    );
+    result =
+        compute_something(first_argument_value, second_argument_value,
+            nested_call(inner_one,
+                inner_two, inner_3));
+}`
+	_testFormat(t, input, expected)
+
+	input = `bool result = (anInt >= aDouble) && (aLong <= anInt) && (aDouble < 3 && anInt++ < ALong) && (aDouble | 3 && anInt++ < ALong);`
+	expected = `bool result = (anInt >= aDouble)
+    && (aLong <= anInt)
+    && (aDouble < 3 && anInt++ < ALong)
+    && (aDouble | 3 && anInt++ < ALong);
+`
+	_testFormat(t, input, expected)
+
+	input = `void f(void) {
+    size_t const tokenSpace = ZSTD_cwksp_alloc_size(WILDCOPY_OVERLENGTH + blockSize) + ZSTD_cwksp_aligned64_alloc_size(maxNbSeq * sizeof(SeqDef)) + 3 * ZSTD_cwksp_alloc_size(maxNbSeq * sizeof(BYTE));
+    stats = nbSeq != 0 ? ZSTD_buildSequencesStatistics(seqStorePtr, nbSeq, prevEntropy, nextEntropy, op, oend, strategy) : ZSTD_buildDummySequencesStatistics(nextEntropy);
+}`
+	expected = `void f(void) {
+    size_t const tokenSpace = ZSTD_cwksp_alloc_size(WILDCOPY_OVERLENGTH + blockSize)
+        + ZSTD_cwksp_aligned64_alloc_size(maxNbSeq * sizeof(SeqDef))
+        + 3 * ZSTD_cwksp_alloc_size(maxNbSeq * sizeof(BYTE));
+    stats = nbSeq != 0
+        ? ZSTD_buildSequencesStatistics(seqStorePtr, nbSeq, prevEntropy, nextEntropy, op, oend, strategy)
+        : ZSTD_buildDummySequencesStatistics(nextEntropy);
+}
+`
+	_testFormat(t, input, expected)
+
+	input = `void f(void) {
+    const BYTE *iend = istart + blockSize; /* May be adjusted if we decide to process fewer than blockSize bytes */
+}`
+	expected = `void f(void) {
+    const BYTE *iend = istart + blockSize; /* May be adjusted if we decide to process fewer than blockSize bytes */
+}
+`
+	_testFormat(t, input, expected)
+
+	// A call in a chain in fill mode is only broken once the chain is
+	input = `void f(void) {
+    xxxxxxxx = function_one(alpha, beta) + function_two(gamma, delta);
+}`
+	expected = `void f(void) {
+    xxxxxxxx = function_one(alpha, beta)
+        + function_two(gamma, delta);
+}
+`
+	options := defaultOptions()
+	options.ColumnLimit = 50
+	options.ArgumentWrapping = ArgumentWrappingBinPack
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatArgumentWrapping(t *testing.T) {
+	input := `void c8_glyph(C8_State *state, C8_Glyph glyph, float x, float y, float width, float height) {
+    c8_text_vertex(state, x + width, y + height, rgb.r, rgb.g, glyph.u_right, glyph.v_bottom);
+    c8_text_vertex_with_a_long_name(state, "a string literal that does not fit after it");
+}`
+
+	options := defaultOptions()
+	options.ColumnLimit = 60
+	options.ArgumentWrapping = ArgumentWrappingBinPack
+	expected := `void c8_glyph(
+    C8_State *state,
+    C8_Glyph glyph,
+    float x,
+    float y,
+    float width,
+    float height
+) {
+    c8_text_vertex(
+        state, x + width, y + height, rgb.r, rgb.g,
+        glyph.u_right, glyph.v_bottom
+    );
+    c8_text_vertex_with_a_long_name(
+        state, "a string literal that does not fit after it"
+    );
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.ArgumentWrapping = ArgumentWrappingOnePerLine
+	options.ParameterWrapping = ArgumentWrappingAlign
+	expected = `void c8_glyph(C8_State *state, C8_Glyph glyph, float x,
+              float y, float width, float height) {
+    c8_text_vertex(
+        state,
+        x + width,
+        y + height,
+        rgb.r,
+        rgb.g,
+        glyph.u_right,
+        glyph.v_bottom
+    );
+    c8_text_vertex_with_a_long_name(
+        state,
+        "a string literal that does not fit after it"
+    );
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.ArgumentWrapping = ArgumentWrappingAlign
+	options.ParameterWrapping = ArgumentWrappingOnePerLine
+	expected = `void c8_glyph(
+    C8_State *state,
+    C8_Glyph glyph,
+    float x,
+    float y,
+    float width,
+    float height
+) {
+    c8_text_vertex(state, x + width, y + height, rgb.r,
+                   rgb.g, glyph.u_right, glyph.v_bottom);
+    c8_text_vertex_with_a_long_name(
+        state,
+        "a string literal that does not fit after it");
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.UseTabs = true
+	options.TabWidth = 8
+	options.IndentWidth = 8
+	options.ColumnLimit = 70
+	expected = "void c8_glyph(\n\tC8_State *state,\n\tC8_Glyph glyph,\n\tfloat x,\n\tfloat y,\n\tfloat width,\n\tfloat height\n) {\n" +
+		"\tc8_text_vertex(state, x + width, y + height, rgb.r, rgb.g,\n\t\t       glyph.u_right, glyph.v_bottom);\n" +
+		"\tc8_text_vertex_with_a_long_name(\n\t\tstate, \"a string literal that does not fit after it\");\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	// Levels of 4 columns, two to a tab
+	options.IndentWidth = 4
+	expected = "void c8_glyph(\n    C8_State *state,\n    C8_Glyph glyph,\n    float x,\n    float y,\n    float width,\n    float height\n) {\n" +
+		"    c8_text_vertex(state, x + width, y + height, rgb.r, rgb.g,\n\t\t   glyph.u_right, glyph.v_bottom);\n" +
+		"    c8_text_vertex_with_a_long_name(\n\tstate, \"a string literal that does not fit after it\");\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
 }
 
 func TestFormatShader(t *testing.T) {
@@ -1625,3 +1827,1916 @@ void main() {
 	_testFormat(t, input, expected)
 
 }
+
+func TestFormatDirectiveIndent(t *testing.T) {
+	options := defaultOptions()
+	options.DirectiveIndent = DirectiveIndentAfterHash
+
+	input := `#ifndef FOO_H
+#define FOO_H
+#ifdef _WIN32
+#if defined(_M_X64)
+#define ARCH "x64"
+#else
+#define ARCH "x86"
+#endif
+#elif defined(__linux__)
+#include <unistd.h>
+#endif
+#endif
+`
+	expected := `#ifndef FOO_H
+
+#define FOO_H
+
+#ifdef _WIN32
+
+#    if defined(_M_X64)
+
+#        define ARCH "x64"
+
+#    else
+
+#        define ARCH "x86"
+
+#    endif
+
+#elif defined(__linux__)
+
+#    include <unistd.h>
+
+#endif
+
+#endif
+`
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+
+	options.IndentIncludeGuard = true
+	expected = `#ifndef FOO_H
+
+#    define FOO_H
+
+#    ifdef _WIN32
+
+#        if defined(_M_X64)
+
+#            define ARCH "x64"
+
+#        else
+
+#            define ARCH "x86"
+
+#        endif
+
+#    elif defined(__linux__)
+
+#        include <unistd.h>
+
+#    endif
+
+#endif
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options = defaultOptions()
+	options.DirectiveIndent = DirectiveIndentBeforeHash
+	input = "#ifdef FOO\n#  ifdef BAR\nint i = 1;\n#  endif\n#endif\n"
+	expected = "#ifdef FOO\n\n    #ifdef BAR\n\nint i = 1;\n\n    #endif\n\n#endif\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	input = "#ifdef FOO\n#  ifdef BAR\nint i = 1;\n#  endif\n#endif\n"
+	expected = "#ifdef FOO\n\n#ifdef BAR\n\nint i = 1;\n\n#endif\n\n#endif\n"
+	_testFormat(t, input, expected)
+}
+
+func TestFormatConditionalBranches(t *testing.T) {
+	input := `#if defined(WIDE)
+int f(long a) {
+#else
+int f(int a) {
+#endif
+    return a;
+}
+`
+	expected := `#if defined(WIDE)
+
+int f(long a) {
+#else
+
+int f(int a) {
+#endif
+    return a;
+}
+`
+	_testFormat(t, input, expected)
+
+	input = `void g() {
+#if A
+if (a) {
+#elif B
+if (b) {
+#else
+if (c) {
+#endif
+foo();
+}
+}
+`
+	expected = `void g() {
+#if A
+    if (a) {
+#elif B
+    if (b) {
+#else
+    if (c) {
+#endif
+        foo();
+    }
+}
+`
+	_testFormat(t, input, expected)
+
+	input = "#ifdef __cplusplus\nextern \"C\" {\n#endif\n"
+	_, err := Format(input)
+
+	if err == nil {
+		t.Errorf("Unclosed block in a branch without alternatives should be an error")
+	}
+}
+
+func TestFormatLinkageSpecification(t *testing.T) {
+	input := `extern "C" {
+    struct Foo {int a;};
+    int foo(int a, int b){return a;}
+    int bar(void);
+}
+int baz;
+`
+	expected := `extern "C" {
+struct Foo {
+    int a;
+};
+
+int foo(int a, int b) {
+    return a;
+}
+
+int bar(void);
+}
+
+int baz;
+`
+	_testFormat(t, input, expected)
+
+	input = `#ifdef __cplusplus
+extern "C" {
+#endif
+int foo(void);
+#ifdef __cplusplus
+}
+#endif
+`
+	expected = `#ifdef __cplusplus
+
+extern "C" {
+#endif
+
+int foo(void);
+
+#ifdef __cplusplus
+}
+
+#endif
+`
+	_testFormat(t, input, expected)
+}
+
+func TestFormatSwitch(t *testing.T) {
+	input := `void f() {
+switch (a) {
+case A: x = 1;
+    // fallthrough
+case B: {
+    y();
+} break;
+case 1 ... 5: // a GNU case range
+    switch (b) {
+    case 1: return;
+    }
+    break;
+default:
+    break;
+}
+}
+`
+	expected := `void f() {
+    switch (a) {
+        case A:
+            x = 1;
+            // fallthrough
+        case B: {
+            y();
+        }
+            break;
+        case 1 ... 5: // a GNU case range
+            switch (b) {
+                case 1:
+                    return;
+            }
+            break;
+        default:
+            break;
+    }
+}
+`
+	_testFormat(t, input, expected)
+	_testFormat(t, expected, expected)
+
+	options := defaultOptions()
+	options.IndentCaseLabels = false
+	expected = `void f() {
+    switch (a) {
+    case A:
+        x = 1;
+        // fallthrough
+    case B: {
+        y();
+    }
+        break;
+    case 1 ... 5: // a GNU case range
+        switch (b) {
+        case 1:
+            return;
+        }
+        break;
+    default:
+        break;
+    }
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatGotoLabel(t *testing.T) {
+	input := "void f() {\ngoto end;\nend: return;\n}\n"
+	expected := "void f() {\n    goto end;\n    end:\n    return;\n}\n"
+	_testFormat(t, input, expected)
+
+	options := defaultOptions()
+	options.GotoLabelIndent = LabelIndentFlush
+	input = "void f() {\nif (a) {\ngoto end;\n}\nend:\nreturn;\n}\n"
+	expected = "void f() {\n    if (a) {\n        goto end;\n    }\nend:\n    return;\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.GotoLabelIndent = LabelIndentOutdent
+	input = "void f() {\nif (a) {\ngoto end;\nend:\nreturn;\n}\n}\n"
+	expected = "void f() {\n    if (a) {\n        goto end;\n    end:\n        return;\n    }\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatImplicitBlock(t *testing.T) {
+	input := `void f() {
+if (a)
+foo();
+else if (b)
+bar();
+else
+baz();
+for (i = 0; i < 3; i++)
+if (x) // comment
+y();
+else
+z();
+while (a) b();
+do
+x++;
+while (a);
+if (a)
+if (b)
+x();
+else
+y();
+else
+do {
+x();
+} while (b);
+return;
+}
+`
+	expected := `void f() {
+    if (a)
+        foo();
+    else if (b)
+        bar();
+    else
+        baz();
+    for (i = 0; i < 3; i++)
+        if (x) // comment
+            y();
+        else
+            z();
+    while (a) b();
+    do
+        x++;
+    while (a);
+    if (a)
+        if (b)
+            x();
+        else
+            y();
+    else
+        do {
+            x();
+        } while (b);
+    return;
+}
+`
+	_testFormat(t, input, expected)
+
+	input = `void f() {
+if (x)
+y = 1; // short
+while (x)
+y = 2; /* a comment */
+if (x)
+y = 1; /* a rather long trailing comment that goes on and on and on and on past the column limit */
+z = 3;
+}`
+	expected = `void f() {
+    if (x)
+        y = 1; // short
+    while (x)
+        y = 2; /* a comment */
+    if (x)
+        y = 1; /* a rather long trailing comment that goes on and on and on and on past the column limit */
+    z = 3;
+}
+`
+	_testFormat(t, input, expected)
+	_testFormat(t, expected, expected)
+
+	// The ) of the while (0) ends the directive, it does not start a body
+	input = `#define M(x) do { foo(x); } while (0)
+
+int a;
+`
+	expected = `#define M(x) do {foo(x);\
+} while (0)
+
+int a;
+`
+	_testFormat(t, input, expected)
+}
+
+func TestFormatInsertBraces(t *testing.T) {
+	options := defaultOptions()
+	options.InsertBraces = true
+
+	input := `void f() {
+if (a)
+foo();
+else if (b)
+bar(); // trailing
+else
+baz();
+for (i = 0; i < 3; i++)
+if (x) y();
+else z();
+do
+x++;
+while (a);
+if (a)
+LOG(x);
+if (a)
+#ifdef X
+foo();
+#else
+bar();
+#endif
+}
+`
+	expected := `void f() {
+    if (a) {
+        foo();
+    }
+    else if (b) {
+        bar(); // trailing
+    }
+    else {
+        baz();
+    }
+    for (i = 0; i < 3; i++) {
+        if (x) {
+            y();
+        }
+        else {
+            z();
+        }
+    }
+    do {
+        x++;
+    } while (a);
+    if (a)
+        LOG(x);
+    if (a)
+#ifdef X
+        foo();
+#else
+        bar();
+#endif
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+}
+
+func TestCheckTokens(t *testing.T) {
+	tokens := tokenize("int a = b;")
+
+	if checkTokens(nil, tokens, "int a = b; // comment\n") != nil {
+		t.Errorf("Comments should be ignored")
+	}
+
+	if checkTokens(nil, tokens, "int a = c;\n") == nil {
+		t.Errorf("Changed tokens should be reported")
+	}
+
+	if checkTokens(nil, tokens, "int a = b;;\n") == nil {
+		t.Errorf("Additional tokens should be reported")
+	}
+
+	input := tokenize("if (a) b(); else c();")
+	tokens = lexTokens("if (a) { b(); } else { c(); }")
+
+	for _, i := range []int{4, 9, 11, 16} {
+		tokens[i].Inserted = true
+	}
+
+	if checkTokens(input, tokens, "if (a) {\n    b();\n} else {\n    c();\n}\n") != nil {
+		t.Errorf("Inserted tokens should be ignored")
+	}
+
+	// A brace inserted in place of a token of the input, which the formatter has written
+	tokens = lexTokens("if (a) { (); } else { c(); }")
+
+	for _, i := range []int{4, 8, 10, 15} {
+		tokens[i].Inserted = true
+	}
+
+	if checkTokens(input, tokens, "if (a) {\n    ();\n} else {\n    c();\n}\n") == nil {
+		t.Errorf("Tokens dropped by an insertion should be reported")
+	}
+
+	tokens = lexTokens("if (a) { b(); } else { c(); }")
+
+	if checkTokens(input, tokens, "if (a) {\n    b();\n} else {\n    c();\n}\n") == nil {
+		t.Errorf("Tokens added without being marked as inserted should be reported")
+	}
+}
+
+func TestFormatBraceStyle(t *testing.T) {
+	input := `struct Foo {int a;};
+int x[] = {1, 2};
+int main(int argc, char **argv) {
+    if (a) {
+        foo();
+    }
+    do {
+        z();
+    } while (a);
+    switch (a) {
+        case 1: {
+            break;
+        }
+    }
+}
+`
+	options := defaultOptions()
+	options.FunctionBraces = BraceStyleAllman
+	expected := `struct Foo {
+    int a;
+};
+
+int x[] = {1, 2};
+
+int main(int argc, char **argv)
+{
+    if (a) {
+        foo();
+    }
+    do {
+        z();
+    } while (a);
+    switch (a) {
+        case 1: {
+            break;
+        }
+    }
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.ControlBraces = BraceStyleGnu
+	options.TypeBraces = BraceStyleAllman
+	expected = `struct Foo
+{
+    int a;
+};
+
+int x[] = {1, 2};
+
+int main(int argc, char **argv)
+{
+    if (a)
+        {
+            foo();
+        }
+    do
+        {
+            z();
+        } while (a);
+    switch (a)
+        {
+            case 1: {
+                break;
+            }
+        }
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	input = `Foo foo = {"123", //A comment
+123};
+`
+	options = defaultOptions()
+	options.InitializerBraces = BraceStyleAllman
+	expected = `Foo foo =
+{
+    "123", // A comment
+    123
+};
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestStylePresets(t *testing.T) {
+	input := `int main(int argc, char **argv) {
+switch (argc) {
+case 1: return 0;
+}
+}
+`
+	options, _ := resolveOptions([]Setting{{"style", "linux"}})
+	expected := "int main(int argc, char **argv)\n{\n\tswitch (argc) {\n\tcase 1:\n\t\treturn 0;\n\t}\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	options, _ = resolveOptions([]Setting{{"style", "llvm"}})
+	expected = "int main(int argc, char **argv) {\n  switch (argc) {\n  case 1:\n    return 0;\n  }\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	settings, err := parseConfig("# Start from linux\nstyle = linux\n\nuse-tabs = false\nindent-width=4\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, _ = resolveOptions(settings)
+	expected = "int main(int argc, char **argv)\n{\n    switch (argc) {\n    case 1:\n        return 0;\n    }\n}\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	options, _ = resolveOptions(append(settings, Setting{"style", "cfmt"}))
+
+	if options.FunctionBraces != BraceStyleAttach || options.IndentWidth != 4 || options.UseTabs {
+		t.Errorf("Settings should apply to the style given last, found %v", options)
+	}
+
+	_, err = parseConfig("indent-width = four\n")
+
+	if err == nil {
+		t.Errorf("Invalid values should be reported")
+	}
+
+	_, err = parseConfig("style linux\n")
+
+	if err == nil {
+		t.Errorf("Lines without = should be reported")
+	}
+}
+
+func TestParseYaml(t *testing.T) {
+	documents, err := parseYaml(`---
+# Comment
+Language: Cpp
+ColumnLimit: 100 # Trailing comment
+BraceWrapping:
+  AfterFunction: true
+  AfterEnum: 'false'
+IncludeCategories:
+  - Regex: '^<.*\.h>'
+    Priority: 1
+  - Regex: "^\"local"
+    Priority: 2
+Macros: [A, 'B, C']
+---
+Language: JavaScript
+...
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(documents) != 2 {
+		t.Fatalf("Expected 2 documents, found %d", len(documents))
+	}
+
+	document := documents[0]
+
+	if document.get("ColumnLimit").Value != "100" {
+		t.Errorf("Expected ColumnLimit 100, found %s", document.get("ColumnLimit").Value)
+	}
+
+	if document.get("BraceWrapping").get("AfterEnum").Value != "false" {
+		t.Errorf("Expected quoted scalars to be unquoted")
+	}
+
+	categories := document.get("IncludeCategories")
+
+	if categories.Kind != YamlKindSequence || len(categories.Children) != 2 {
+		t.Fatalf("Expected a sequence of 2 mappings")
+	}
+
+	if categories.Children[1].get("Regex").Value != `^"local` || categories.Children[1].get("Priority").Value != "2" {
+		t.Errorf("Unexpected sequence item %v", categories.Children[1])
+	}
+
+	macros := document.get("Macros")
+
+	if len(macros.Children) != 2 || macros.Children[1].Value != "B, C" {
+		t.Errorf("Unexpected flow sequence %v", macros)
+	}
+
+	if documents[1].get("Language").Value != "JavaScript" {
+		t.Errorf("Expected the second document to be read")
+	}
+
+	_, err = parseYaml("A: 1\n  B: 2\n")
+
+	if err == nil {
+		t.Errorf("Unexpected indentation should be reported")
+	}
+}
+
+func TestParseClangFormat(t *testing.T) {
+	settings, warnings, err := parseClangFormat(`---
+Language: JavaScript
+IndentWidth: 8
+---
+BasedOnStyle: LLVM
+IndentWidth: 4
+UseTab: ForIndentation
+PointerAlignment: Left
+AlignConsecutiveAssignments:
+  Enabled: true
+AlignTrailingComments:
+  Kind: Always
+  OverEmptyLines: 0
+BreakBeforeBraces: Custom
+BraceWrapping:
+  AfterFunction: true
+  AfterControlStatement: Never
+  AfterStruct: true
+  SplitEmptyFunction: false
+SpacesInParens: Never
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := resolveOptions(settings)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := llvmOptions()
+	expected.IndentWidth = 4
+	expected.UseTabs = true
+	expected.PointerAlignment = PointerAlignmentLeft
+	expected.AlignAssignments = true
+	expected.AlignEnumValues = true
+	expected.AlignTrailingComments = true
+	expected.FunctionBraces = BraceStyleAllman
+	expected.TypeBraces = BraceStyleAllman
+
+	if options != expected {
+		t.Errorf("Expected %v, found %v", expected, options)
+	}
+
+	if len(warnings) != 2 ||
+		warnings[0].Error() != "20: unsupported key SpacesInParens" ||
+		warnings[1].Error() != "19: BraceWrapping: unsupported key SplitEmptyFunction" {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	settings, warnings, err = parseClangFormat("BasedOnStyle: Google\nBreakBeforeBraces: GNU\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, _ = resolveOptions(settings)
+
+	if options.ControlBraces != BraceStyleGnu || options.IndentWidth != 2 || len(warnings) != 1 {
+		t.Errorf("Unexpected options %v and warnings %v", options, warnings)
+	}
+
+	// Values that cannot be used are reported, and keep the default of the style
+	settings, warnings, err = parseClangFormat("ColumnLimit: wide\nIndentGotoLabels: maybe\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, _ = resolveOptions(settings)
+
+	if options.ColumnLimit != 80 || options.GotoLabelIndent != LabelIndentNone || len(warnings) != 2 ||
+		warnings[0].Error() != "1: ColumnLimit: unsupported value wide" ||
+		warnings[1].Error() != "2: IndentGotoLabels: invalid boolean maybe" {
+		t.Errorf("Unexpected options %v and warnings %v", options, warnings)
+	}
+}
+
+func TestFormatLineEndings(t *testing.T) {
+	input := "#define A 1\r\nint main() {\r\n    return A;\r\n}\r\n"
+
+	options := defaultOptions()
+	options.EndOfLine = EndOfLineCrlf
+	expected := "#define A 1\r\n\r\nint main() {\r\n    return A;\r\n}\r\n"
+	_testFormatWithOptions(t, input, expected, options)
+
+	options = defaultOptions()
+	options.InsertFinalNewline = false
+	expected = "#define A 1\n\nint main() {\n    return A;\n}"
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatByteOrderMark(t *testing.T) {
+	input := BYTE_ORDER_MARK + "int a;\n"
+
+	_testFormat(t, input, input)
+
+	options := defaultOptions()
+	options.Charset = CharsetUtf8
+	_testFormatWithOptions(t, input, "int a;\n", options)
+
+	options.Charset = CharsetUtf8Bom
+	_testFormatWithOptions(t, "int a;\n", input, options)
+}
+
+func TestEditorConfigGlob(t *testing.T) {
+	tests := []struct {
+		Glob    string
+		Path    string
+		Matches bool
+	}{
+		{"*", "src/main.c", true},
+		{"*.{c,h}", "main.c", true},
+		{"*.{c,h}", "src/main.h", true},
+		{"*.{c,h}", "main.cpp", false},
+		{"src/*.c", "src/main.c", true},
+		{"src/*.c", "src/lib/main.c", false},
+		{"/src/**.c", "src/lib/main.c", true},
+		{"src/**/main.c", "src/main.c", true},
+		{"lib/*.c", "src/lib/main.c", false},
+		{"file?.c", "file1.c", true},
+		{"file[0-2].c", "file3.c", false},
+		{"file[!0-2].c", "file3.c", true},
+		{"file{1..12}.c", "file12.c", true},
+		{"file{1..12}.c", "file13.c", false},
+		{"file{1..100000000}.c", "file99999999.c", true},
+		{"file{1..100000000}.c", "file100000001.c", false},
+		{"file{-5..5}.c", "file-3.c", true},
+		{"{a,b{1..3}}/*.c", "b2/main.c", true},
+		{"{a,b{1..3}}/*.c", "b4/main.c", false},
+		{"{a,b{1..3}}/*.c", "a/main.c", true},
+		{"{single}.c", "{single}.c", true},
+		{"\\*.c", "main.c", false},
+	}
+
+	for _, test := range tests {
+		glob, err := compileEditorConfigGlob(test.Glob)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.Glob, err)
+			continue
+		}
+
+		if glob.matches(test.Path) != test.Matches {
+			t.Errorf("Expected %s matching %s to be %v", test.Glob, test.Path, test.Matches)
+		}
+	}
+}
+
+func TestEditorConfigProperties(t *testing.T) {
+	outer := t.TempDir()
+	root := filepath.Join(outer, "project")
+	dir := filepath.Join(root, "src")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		filepath.Join(outer, EDITOR_CONFIG_FILE_NAME): "[*]\ncharset = latin1\n",
+		filepath.Join(root, EDITOR_CONFIG_FILE_NAME): `root = true
+
+[*]
+indent_style = tab
+end_of_line = crlf
+max_line_length = 80
+
+[*.{c,h}]
+indent_style = space
+indent_size = 2
+charset = latin1
+`,
+		filepath.Join(dir, EDITOR_CONFIG_FILE_NAME): "[*.c]\nmax_line_length = off\nend_of_line = unset\n",
+	}
+
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	properties, err := editorConfigProperties(filepath.Join(dir, "main.c"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, warnings := editorConfigSettings(properties)
+	options, err := resolveOptions(settings)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := defaultOptions()
+	expected.IndentWidth = 2
+	expected.TabWidth = 2
+	expected.ColumnLimit = 0
+
+	if options != expected {
+		t.Errorf("Expected %v, found %v", expected, options)
+	}
+
+	if len(warnings) != 1 || !strings.HasSuffix(warnings[0].Error(), ":11: charset: unsupported value latin1") {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+}
+
+func TestFormatPointerAlignment(t *testing.T) {
+	input := `static char *copy(const char *s, foo_t **out);
+
+int main(void) {
+    void (*callback)(void *data);
+    bool read(const char *path, Arena *arena);
+    foo_t *p = (foo_t *)malloc(sizeof(foo_t *) * n);
+    char **argv2 = &argv[1];
+    x = *p * 2;
+    return a * b & *c;
+}
+`
+	expected := `static char *copy(const char *s, foo_t **out);
+
+int main(void) {
+    void (*callback) (void *data);
+    bool read(const char *path, Arena *arena);
+    foo_t *p = (foo_t *) malloc(sizeof(foo_t *) * n);
+    char **argv2 = &argv[1];
+    x = *p * 2;
+    return a * b & *c;
+}
+`
+	_testFormat(t, input, expected)
+
+	options := defaultOptions()
+	options.PointerAlignment = PointerAlignmentLeft
+	expected = `static char* copy(const char* s, foo_t** out);
+
+int main(void) {
+    void (*callback) (void* data);
+    bool read(const char* path, Arena* arena);
+    foo_t* p = (foo_t*) malloc(sizeof(foo_t*) * n);
+    char** argv2 = &argv[1];
+    x = *p * 2;
+    return a * b & *c;
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.PointerAlignment = PointerAlignmentMiddle
+	expected = `static char * copy(const char * s, foo_t ** out);
+
+int main(void) {
+    void (*callback) (void * data);
+    bool read(const char * path, Arena * arena);
+    foo_t * p = (foo_t *) malloc(sizeof(foo_t *) * n);
+    char ** argv2 = &argv[1];
+    x = *p * 2;
+    return a * b & *c;
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatAlignConsecutive(t *testing.T) {
+	input := `#define A 1
+#define LONG_NAME 2
+#define F(x) ((x) + 1)
+
+int anInt;
+double aDouble = 2;
+
+struct point {
+    int x;
+    double yy;
+    // A comment breaks the run
+    char *name;
+    unsigned long id;
+};
+
+int main(void) {
+    int x = 1;
+    unsigned long long yy = 2;
+    char *s = "a";
+
+    x = 3;
+    yy += 4;
+    total = x * yy;
+}
+`
+	expected := `#define A         1
+#define LONG_NAME 2
+#define F(x)      ((x) + 1)
+
+int    anInt;
+double aDouble = 2;
+
+struct point {
+    int    x;
+    double yy;
+    // A comment breaks the run
+    char         *name;
+    unsigned long id;
+};
+
+int main(void) {
+    int                x  = 1;
+    unsigned long long yy = 2;
+    char              *s  = "a";
+
+    x     = 3;
+    yy   += 4;
+    total = x * yy;
+}
+`
+	options := defaultOptions()
+	options.AlignDeclarations = true
+	options.AlignAssignments = true
+	options.AlignMacros = true
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.PointerAlignment = PointerAlignmentLeft
+	options.AlignAssignments = false
+	options.AlignMacros = false
+	expected = `#define A 1
+
+#define LONG_NAME 2
+
+#define F(x) ((x) + 1)
+
+int    anInt;
+double aDouble = 2;
+
+struct point {
+    int    x;
+    double yy;
+    // A comment breaks the run
+    char*         name;
+    unsigned long id;
+};
+
+int main(void) {
+    int                x = 1;
+    unsigned long long yy = 2;
+    char*              s = "a";
+
+    x = 3;
+    yy += 4;
+    total = x * yy;
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	// Lines that would end past the limit, or that wrap, are not aligned
+	input = `void f(void) {
+    x = some_function_with_a_long_name(arg1, arg2);
+    long_variable_name = 2;
+    int a;
+    unsigned long value = compute(first_argument, second_argument, third);
+}
+`
+	expected = `void f(void) {
+    x = some_function_with_a_long_name(arg1, arg2);
+    long_variable_name = 2;
+    int a;
+    unsigned long value =
+        compute(first_argument, second_argument, third);
+}
+`
+	options = defaultOptions()
+	options.AlignDeclarations = true
+	options.AlignAssignments = true
+	options.ColumnLimit = 60
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+}
+
+// A statement can start on a line that already holds alignment points, as the parts of
+// a for loop header. Going back to its start undoes what was tried for that line.
+func TestRestoreAlignmentPoints(t *testing.T) {
+	text := "a = 1;"
+	f := Formatter{Input: &text, Tokens: new([]Token), InputLine: new(int), InputColumn: new(int), Options: defaultOptions()}
+	f.pushNode(NodeTypeTopLevel)
+	f.addAlignmentPoint(AlignmentKindAssignment)
+	f.OutputColumn = 8
+	start := f.save()
+
+	f.OutputColumn = 120
+	f.wrapAlignmentLine()
+	f.endAlignmentLine()
+	f.restore(&start)
+
+	if point := f.AlignmentPoints[0]; point.Wraps || point.End != 0 {
+		t.Errorf("Alignment point should be restored, found %+v", point)
+	}
+}
+
+func TestFormatAlignStructMembers(t *testing.T) {
+	input := `typedef struct {
+    unsigned enable : 1; // Enable
+    unsigned int mode:3; // Mode
+    uint32_t reserved_bits : 28;
+    volatile uint32_t *data; // Data
+    union { int i; float value; } u;
+} reg_t;
+
+void f(void) {
+    int a;
+    double b;
+}
+`
+	expected := `typedef struct {
+    unsigned enable: 1; // Enable
+    unsigned int mode: 3; // Mode
+    uint32_t reserved_bits: 28;
+    volatile uint32_t *data; // Data
+    union {
+        int i;
+        float value;
+    } u;
+} reg_t;
+
+void f(void) {
+    int a;
+    double b;
+}
+`
+	_testFormat(t, input, expected)
+
+	options := defaultOptions()
+	options.AlignStructMembers = true
+	expected = `typedef struct {
+    unsigned           enable        : 1; // Enable
+    unsigned int       mode          : 3; // Mode
+    uint32_t           reserved_bits : 28;
+    volatile uint32_t *data; // Data
+    union {
+        int   i;
+        float value;
+    } u;
+} reg_t;
+
+void f(void) {
+    int a;
+    double b;
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatAlignTrailingComments(t *testing.T) {
+	input := `enum e {
+    A, // a
+    BBB = 2, // b
+};
+
+void f(void) {
+    x = 1; // one
+    yyy = 2; // two
+
+    if (x) { // c
+        y(); // d would not fit after the long line
+        zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz(); // z
+        z(); // e
+    }
+}
+`
+	options := defaultOptions()
+	options.AlignTrailingComments = true
+	options.ColumnLimit = 70
+	expected := `enum e {
+    A,       // a
+    BBB = 2, // b
+};
+
+void f(void) {
+    x = 1;   // one
+    yyy = 2; // two
+
+    if (x) {
+        // c
+        y(); // d would not fit after the long line
+        zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz(); // z
+        z(); // e
+    }
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.TrailingCommentGap = 2
+	options.ColumnLimit = 0
+	expected = `enum e {
+    A,        // a
+    BBB = 2,  // b
+};
+
+void f(void) {
+    x = 1;    // one
+    yyy = 2;  // two
+
+    if (x) {
+        // c
+        y();                                             // d would not fit after the long line
+        zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz();  // z
+        z();                                             // e
+    }
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatEnumValues(t *testing.T) {
+	input := `enum color {RED, GREEN = 2, BLUE_LONG = 0x10, MASK = (1 << 3) // mask
+};
+
+typedef enum {TableType_None,TableType_Acnt,} TableType;
+
+enum e {X,
+// last
+};
+
+enum empty {};
+`
+	options := defaultOptions()
+	options.AlignEnumValues = true
+	expected := `enum color {
+    RED,
+    GREEN     = 2,
+    BLUE_LONG = 0x10,
+    MASK      = (1 << 3) // mask
+};
+
+typedef enum {
+    TableType_None,
+    TableType_Acnt,
+} TableType;
+
+enum e {
+    X,
+    // last
+};
+
+enum empty {
+};
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.AlignEnumValues = false
+	options.EnumTrailingComma = TrailingCommaAdd
+	expected = `enum color {
+    RED,
+    GREEN = 2,
+    BLUE_LONG = 0x10,
+    MASK = (1 << 3), // mask
+};
+
+typedef enum {
+    TableType_None,
+    TableType_Acnt,
+} TableType;
+
+enum e {
+    X,
+    // last
+};
+
+enum empty {
+};
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.EnumTrailingComma = TrailingCommaRemove
+	expected = `enum color {
+    RED,
+    GREEN = 2,
+    BLUE_LONG = 0x10,
+    MASK = (1 << 3) // mask
+};
+
+typedef enum {
+    TableType_None,
+    TableType_Acnt
+} TableType;
+
+enum e {
+    X
+    // last
+};
+
+enum empty {
+};
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatSortIncludes(t *testing.T) {
+	input := `#include <sys/types.h>
+#include "util.h" // helpers
+#include <stdio.h>
+
+#include "foo.h"
+#include <stdio.h>
+#include MACRO_H
+#include "b.h"
+#include "a.h"
+#if X
+#include <z.h>
+#include <b.h>
+#endif
+// separate
+#include <c.h>
+#include <a.h>
+int x;
+`
+	options := defaultOptions()
+	expected := `#include <sys/types.h>
+#include "util.h" // helpers
+#include <stdio.h>
+#include "foo.h"
+#include <stdio.h>
+#include MACRO_H
+#include "b.h"
+#include "a.h"
+
+#if X
+
+#include <z.h>
+#include <b.h>
+
+#endif
+
+// separate
+#include <c.h>
+#include <a.h>
+
+int x;
+`
+	_testFormatWithPath(t, input, "src/foo.c", expected, options)
+
+	options.SortIncludes = true
+	expected = `#include "foo.h"
+
+#include <stdio.h>
+#include <sys/types.h>
+
+#include "util.h" // helpers
+#include MACRO_H
+#include "a.h"
+#include "b.h"
+
+#if X
+
+#include <b.h>
+#include <z.h>
+
+#endif
+
+// separate
+#include <a.h>
+#include <c.h>
+
+int x;
+`
+	_testFormatWithPath(t, input, "src/foo.c", expected, options)
+
+	options.IncludeCategories = `^<sys/ ^"(util|a)\.h"$`
+	expected = `#include "foo.h"
+
+#include <sys/types.h>
+
+#include "util.h" // helpers
+
+#include <stdio.h>
+#include MACRO_H
+#include "a.h"
+
+#include "b.h"
+
+#if X
+
+#include <b.h>
+#include <z.h>
+
+#endif
+
+// separate
+#include <a.h>
+#include <c.h>
+
+int x;
+`
+	_testFormatWithPath(t, input, "src/foo.c", expected, options)
+	// Duplicates keep their trailing comment, and those with different ones are kept
+	input = `#include <stdio.h>
+#include "b.h" // for b_init
+#include <stdio.h> // for printf
+#include "b.h"
+#include "a.h" // x
+#include "a.h" // y
+#include "a.h" // x
+`
+	expected = `#include <stdio.h> // for printf
+
+#include "a.h" // x
+#include "a.h" // y
+#include "b.h" // for b_init
+`
+	options = defaultOptions()
+	options.SortIncludes = true
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+}
+
+func TestParseClangFormatIncludes(t *testing.T) {
+	settings, warnings, err := parseClangFormat(`SortIncludes: CaseSensitive
+IncludeCategories:
+  - Regex: '^<sys/'
+    Priority: 2
+  - Regex: '^"'
+    Priority: 3
+    SortPriority: 0
+  - Regex: '^<'
+    Priority: 1
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := resolveOptions(settings)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !options.SortIncludes || options.IncludeCategories != `^< ^<sys/ ^"` {
+		t.Errorf("Unexpected options %v", options)
+	}
+
+	if len(warnings) != 1 || warnings[0].Error() != "3: IncludeCategories: categories are matched in order of priority" {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+}
+
+func TestParseClangFormatArgumentWrapping(t *testing.T) {
+	settings, warnings, err := parseClangFormat("BasedOnStyle: LLVM\nBinPackParameters: false\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, _ := resolveOptions(settings)
+
+	if options.ArgumentWrapping != ArgumentWrappingAlign || options.ParameterWrapping != ArgumentWrappingOnePerLine {
+		t.Errorf("Unexpected options %v", options)
+	}
+
+	if len(warnings) != 1 || warnings[0].Error() != "2: BinPackParameters: one per line is indented instead of aligned" {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	settings, warnings, err = parseClangFormat("AlignAfterOpenBracket: BlockIndent\nBinPackParameters: AlwaysOnePerLine\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, _ = resolveOptions(settings)
+
+	if options.ArgumentWrapping != ArgumentWrappingBinPack || options.ParameterWrapping != ArgumentWrappingOnePerLine ||
+		len(warnings) != 0 {
+		t.Errorf("Unexpected options %v and warnings %v", options, warnings)
+	}
+
+	settings, _, _ = parseClangFormat("BasedOnStyle: WebKit\n")
+	options, _ = resolveOptions(settings)
+
+	if options.ArgumentWrapping != ArgumentWrappingOnePerLine {
+		t.Errorf("Unexpected options %v", options)
+	}
+}
+
+func TestFormatPragmas(t *testing.T) {
+	input := `#pragma once
+#pragma comment(lib, "kernel32.lib")
+    #pragma comment(lib, "user32.lib")
+ #pragma comment(lib, "D3d9.lib")
+#pragma comment(lib, "Dsound.lib") // audio
+    #pragma comment(lib, "Dsound.lib")
+
+    #pragma comment(lib, "ole32.lib")
+#pragma warning(push)
+#pragma warning(push)
+#pragma comment(lib, "Comdlg32.lib")
+#pragma comment(lib, "kernel32.lib")
+// separate
+#pragma comment(lib, "kernel32.lib")
+int x;
+`
+	options := defaultOptions()
+	options.DeduplicatePragmas = true
+	expected := `#pragma once
+#pragma comment(lib, "kernel32.lib")
+#pragma comment(lib, "user32.lib")
+#pragma comment(lib, "D3d9.lib")
+#pragma comment(lib, "Dsound.lib") // audio
+#pragma comment(lib, "Dsound.lib")
+#pragma comment(lib, "ole32.lib")
+#pragma warning(push)
+#pragma warning(push)
+#pragma comment(lib, "Comdlg32.lib")
+
+// separate
+#pragma comment(lib, "kernel32.lib")
+
+int x;
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.DeduplicatePragmas = false
+	options.SortPragmaLibraries = true
+	expected = `#pragma once
+#pragma comment(lib, "D3d9.lib")
+#pragma comment(lib, "Dsound.lib") // audio
+#pragma comment(lib, "Dsound.lib")
+#pragma comment(lib, "kernel32.lib")
+#pragma comment(lib, "ole32.lib")
+#pragma comment(lib, "user32.lib")
+#pragma warning(push)
+#pragma warning(push)
+#pragma comment(lib, "Comdlg32.lib")
+#pragma comment(lib, "kernel32.lib")
+
+// separate
+#pragma comment(lib, "kernel32.lib")
+
+int x;
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatHeaderGuard(t *testing.T) {
+	input := `// Copyright
+#ifndef OLD_FOO_H
+#define OLD_FOO_H
+
+#if X
+int y;
+#endif
+
+int foo(void);
+
+#endif // OLD_FOO_H
+`
+	options := defaultOptions()
+	options.HeaderGuard = HeaderGuardIfndef
+	options.HeaderGuardTemplate = "PROJECT_{DIR}_{FILE}_{EXT}"
+	expected := `// Copyright
+#ifndef PROJECT_UTIL_FOO_H
+
+#define PROJECT_UTIL_FOO_H
+
+#if X
+
+int y;
+
+#endif
+
+int foo(void);
+
+#endif // PROJECT_UTIL_FOO_H
+`
+	_testFormatWithPath(t, input, "src/util/foo.h", expected, options)
+
+	// Sources are left alone
+	_testFormatWithPath(t, expected, "src/util/foo.c", expected, options)
+
+	options.HeaderGuard = HeaderGuardPragmaOnce
+	expected = `// Copyright
+#pragma once
+
+#if X
+
+int y;
+
+#endif
+
+int foo(void);
+`
+	_testFormatWithPath(t, input, "src/util/foo.h", expected, options)
+
+	options.HeaderGuard = HeaderGuardIfndef
+	options.HeaderGuardTemplate = "{PATH}"
+	input = expected
+	expected = `// Copyright
+#ifndef SRC_UTIL_FOO_H
+
+#define SRC_UTIL_FOO_H
+
+#if X
+
+int y;
+
+#endif
+
+int foo(void);
+
+#endif
+`
+	_testFormatWithPath(t, input, "src/util/foo.h", expected, options)
+
+	// A conditional that does not enclose the whole file is not a guard
+	input = `#ifndef FOO_H
+#define FOO_H
+#endif
+
+int foo(void);
+`
+	expected = `#ifndef FOO_H
+
+#define FOO_H
+
+#endif
+
+int foo(void);
+`
+	_testFormatWithPath(t, input, "src/util/foo.h", expected, options)
+}
+
+func TestCheckHeaderGuard(t *testing.T) {
+	options := defaultOptions()
+	guarded := "#ifndef FOO_H\n#define FOO_H\nint foo(void);\n#endif\n"
+	pragmaOnce := "/* foo */\n#pragma once\nint foo(void);\n"
+
+	tests := []struct {
+		input       string
+		path        string
+		headerGuard HeaderGuard
+		expected    string
+	}{
+		{guarded, "foo.h", HeaderGuardKeep, ""},
+		{guarded, "foo.c", HeaderGuardPragmaOnce, ""},
+		{guarded, "bar.h", HeaderGuardKeep, "1: include guard FOO_H should be BAR_H"},
+		{guarded, "foo.h", HeaderGuardPragmaOnce, "1: include guard FOO_H instead of #pragma once"},
+		{pragmaOnce, "foo.h", HeaderGuardKeep, ""},
+		{pragmaOnce, "foo.h", HeaderGuardIfndef, "2: #pragma once instead of include guard FOO_H"},
+		{"int foo(void);\n", "foo.h", HeaderGuardKeep, "1: missing include guard"},
+		{"int foo(void);\n", "foo.h", HeaderGuardPragmaOnce, "1: missing #pragma once"},
+	}
+
+	for _, test := range tests {
+		options.HeaderGuard = test.headerGuard
+		err := checkHeaderGuard(test.input, test.path, options)
+
+		if (err == nil && test.expected != "") || (err != nil && err.Error() != test.expected) {
+			t.Errorf("Expected %q for %s, found %v", test.expected, test.path, err)
+		}
+	}
+}
+
+func TestFormatDecoratedComments(t *testing.T) {
+	input := `/**
+   * Doxygen comment.
+*
+     * @param x value
+   */
+void f(int x) {
+if (x) {
+  /*************
+   * Banner    *
+   *************/
+  g();
+	/* +---+     +---+
+	   | a | --> | b |
+	   +---+     +---+ */
+  h();
+      /* Code:
+             if (x)
+                 y();
+       */
+}
+}
+`
+	expected := `/**
+ * Doxygen comment.
+ *
+ * @param x value
+ */
+void f(int x) {
+    if (x) {
+        /*************
+         * Banner    *
+         *************/
+        g();
+        /* +---+     +---+
+           | a | --> | b |
+           +---+     +---+ */
+        h();
+        /* Code:
+               if (x)
+                   y();
+         */
+    }
+}
+`
+	_testFormat(t, input, expected)
+
+	input = `void f(void) {
+/* First line
+ *   second line
+   * third line */
+}
+`
+	options := defaultOptions()
+	options.VerbatimBlockComments = true
+	expected = `void f(void) {
+    /* First line
+     *   second line
+       * third line */
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+
+	options.VerbatimBlockComments = false
+	expected = `void f(void) {
+    /* First line
+     *   second line
+     * third line */
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestFormatInlineComments(t *testing.T) {
+	input := `void f(void) {
+foo(/*verbose=*/true, /*count=*/ 3);
+int x /* meters */ = 5;
+bar(a /* first */, b);
+/*static*/ int y = 1;
+if (x) /* why */ {
+g();
+}
+return; /* done */
+}
+/* own line */
+int z; /* trailing */
+`
+	expected := `void f(void) {
+    foo(/*verbose=*/true, /*count=*/ 3);
+    int x /* meters */ = 5;
+    bar(a /* first */, b);
+    /*static*/ int y = 1;
+    if (x) /* why */ {
+        g();
+    }
+    return; /* done */
+}
+
+/* own line */
+int z; /* trailing */
+`
+	_testFormat(t, input, expected)
+	_testFormat(t, expected, expected)
+}
+
+func TestFormatReflowComments(t *testing.T) {
+	input := `// A long comment that goes past the limit of the
+// line, with
+// short lines.
+//
+// - An item that needs wrapping at this limit.
+// - Short item
+//
+//     code(sample, kept, as, it, is, past, the, limit);
+//
+// See https://example.com/a/long/url/that/is/never/split
+int x;
+
+/**
+ * Does something useful with the value given to it.
+ *
+ * @param value the value
+ */
+void f(int value) {
+    /* A plain block comment that needs rewrapping.
+       Second line. */
+    g(); // trailing comments are left alone even when they are long
+}
+`
+	options := defaultOptions()
+	options.ReflowComments = true
+	options.ColumnLimit = 40
+	expected := `// A long comment that goes past the
+// limit of the line, with short lines.
+//
+// - An item that needs wrapping at this
+//   limit.
+// - Short item
+//
+//     code(sample, kept, as, it, is, past, the, limit);
+//
+// See
+// https://example.com/a/long/url/that/is/never/split
+int x;
+
+/**
+ * Does something useful with the value
+ * given to it.
+ *
+ * @param value the value
+ */
+void f(int value) {
+    /*
+       A plain block comment that needs
+       rewrapping. Second line.
+    */
+    g(); // trailing comments are left alone even when they are long
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+}
+
+func TestFormatSplitStrings(t *testing.T) {
+	input := `const char *message = "A long message that goes past the column limit of fifty";
+void f(void) {
+    printf("Value: %-10.3f and a tail of words past the limit\n", value);
+    wprintf(L"Wide literal with \x41\x42 escapes that is split", x);
+    puts("Short");
+}
+`
+	options := defaultOptions()
+	options.SplitStrings = true
+	options.ColumnLimit = 50
+	expected := `const char *message = "A long message that goes "
+    "past the column limit of fifty";
+
+void f(void) {
+    printf("Value: %-10.3f and a tail of words "
+        "past the limit\n", value);
+    wprintf(L"Wide literal with \x41\x42 escapes "
+        L"that is split", x);
+    puts("Short");
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+
+	input = `static const char *names[] = {"first name that is rather long and goes past the limit", "second"};
+`
+	expected = `static const char *names[] = {
+    "first name that is rather long and goes "
+        "past the limit", "second"
+};
+`
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+
+	input = `printf(
+    "ERROR\n" "Function           %s\n"
+        "Input              %f\n"
+        "Expected           %.60f\n"
+        "Found              %.60f\n"
+        "Over epsilon by    %.60f\n\n",
+    functionTest.functionName,
+    test.input,
+    test.expected,
+    found,
+    overTolleranceBy
+);
+`
+	options.ColumnLimit = MAX_COLUMNS
+	_testFormatWithOptions(t, input, input, options)
+
+	// The literal fits on a line of its own, but its comma does not
+	input = `void f(void) {
+    printf("xxxxxxxxxxa literal that is long enough to need its own line, which is %d columns of the formatter\n", 110);
+}
+`
+	expected = `void f(void) {
+    printf("xxxxxxxxxxa literal that is long enough to need its own line, which is %d columns of the "
+        "formatter\n", 110);
+}
+`
+	_testFormatWithOptions(t, input, expected, options)
+	_testFormatWithOptions(t, expected, expected, options)
+	// An empty literal is one piece
+	input = `void f(void) {
+    call_something(first_argument, "", x);
+}
+`
+	expected = `void f(void) {
+    call_something(
+        first_argument,
+        "",
+        x
+    );
+}
+`
+	options.ColumnLimit = 30
+	_testFormatWithOptions(t, input, expected, options)
+}
+
+func TestLongLineWarnings(t *testing.T) {
+	input := `const char *message = "A literal that cannot be wrapped";
+void f(void) {
+    g(a_rather_long_argument_name);
+    h(x);
+}
+#define MACRO(a) do_something_with(a)
+`
+	options := defaultOptions()
+	options.ColumnLimit = 30
+	_, warnings, err := formatWithWarnings(input, "", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"2: line of 39 columns exceeds the limit of 30 (top level)",
+		"5: line of 35 columns exceeds the limit of 30 (function or macro call)",
+		"9: line of 37 columns exceeds the limit of 30 (directive)",
+	}
+
+	if len(warnings) != len(expected) {
+		t.Fatalf("Unexpected warnings %v", warnings)
+	}
+
+	for i, warning := range warnings {
+		if warning.Error() != expected[i] {
+			t.Errorf("Warning should be %s, found %s", expected[i], warning)
+		}
+	}
+
+	// The lines of a literal continued with a backslash count
+	input = "const char *s = \"a\\\nb\";\nvoid f(void) {\n    g(a_rather_long_argument_name);\n}\n"
+	_, warnings, _ = formatWithWarnings(input, "", options)
+
+	if len(warnings) != 1 || warnings[0].Error() != "5: line of 35 columns exceeds the limit of 30 (function or macro call)" {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	options.ColumnLimit = 0
+	_, warnings, _ = formatWithWarnings(input, "", options)
+
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func tokenize(text string) []Token {
+	result := []Token{}
+
+	for {
+		text = strings.TrimLeft(text, " \t\r\n\v\f")
+
+		if strings.HasPrefix(text, "\\\n") || strings.HasPrefix(text, "\\\r\n") {
+			text = text[strings.Index(text, "\n")+1:]
+			continue
+		}
+
+		token := parseToken(text)
+
+		if token.isAbsent() || token.isInvalid() {
+			return result
+		}
+
+		result = append(result, token)
+		text = text[len(token.Content):]
+	}
+}
+
+func isSameToken(a Token, b Token) bool {
+	if a.isDirective() || b.isDirective() {
+		return a.isDirective() && b.isDirective() && a.DirectiveType == b.DirectiveType
+	}
+
+	return a.Type == b.Type && a.Content == b.Content
+}
+
+// Returns the tokens other than comments
+func codeTokens(tokens []Token) []Token {
+	result := []Token{}
+
+	for _, token := range tokens {
+		if !token.isComment() && !token.isAbsent() {
+			result = append(result, token)
+		}
+	}
+
+	return result
+}
+
+// Whether the formatter can change the tokens of the input other than by adding tokens
+// it marks as inserted, so that the output cannot be compared with the input
+func rewritesTokens(options Options) bool {
+	return options.EnumTrailingComma == TrailingCommaRemove || options.SortIncludes || options.DeduplicatePragmas ||
+		options.SortPragmaLibraries || options.HeaderGuard != HeaderGuardKeep || options.SplitStrings
+}
+
+// Verifies that found holds the same tokens as expected
+func compareTokens(expected []Token, found []Token) error {
+	for i, token := range expected {
+		if i >= len(found) {
+			return fmt.Errorf("%d:%d token %s missing from output", token.Line+1, token.Column+1, token.Content)
+		}
+
+		if !isSameToken(token, found[i]) {
+			return fmt.Errorf("%d:%d token %s changed to %s", token.Line+1, token.Column+1, token.Content, found[i].Content)
+		}
+	}
+
+	if len(found) > len(expected) {
+		return fmt.Errorf("unexpected token %s in output", found[len(expected)].Content)
+	}
+
+	return nil
+}
+
+// Verifies that output contains the tokens the formatter has written, comments aside,
+// and that these are the tokens of the input, except for the ones it has inserted. The
+// input is nil when the formatter rewrites tokens, and then only the first holds.
+func checkTokens(input []Token, tokens []Token, output string) error {
+	written := codeTokens(tokens)
+	found := codeTokens(tokenize(output))
+
+	if err := compareTokens(written, found); err != nil || input == nil {
+		return err
+	}
+
+	kept := []Token{}
+
+	for i, token := range found {
+		if !written[i].Inserted {
+			kept = append(kept, token)
+		}
+	}
+
+	return compareTokens(codeTokens(input), kept)
+}
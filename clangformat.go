@@ -0,0 +1,596 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var CLANG_FORMAT_FILE_NAMES = [...]string{".clang-format", "_clang-format"}
+
+// ClangFormatKey maps a .clang-format key onto cfmt settings, or returns an error if
+// the value has no equivalent
+type ClangFormatKey struct {
+	Name string
+	Map  func(value *YamlNode) ([]Setting, error)
+}
+
+var clangFormatKeys = [...]ClangFormatKey{
+	{"Language", nil},
+	{"BasedOnStyle", nil},
+	{"ColumnLimit", clangFormatScalar("column-limit")},
+	{"IndentWidth", clangFormatScalar("indent-width")},
+	{"TabWidth", clangFormatScalar("tab-width")},
+	{"UseTab", mapClangFormatUseTab},
+	{"IndentCaseLabels", clangFormatBool("IndentCaseLabels", "indent-case-labels")},
+	{"IndentGotoLabels", mapClangFormatIndentGotoLabels},
+	{"IndentPPDirectives", mapClangFormatIndentPPDirectives},
+	{"InsertBraces", clangFormatBool("InsertBraces", "insert-braces")},
+	{"BreakBeforeBraces", mapClangFormatBreakBeforeBraces},
+	{"BraceWrapping", nil},
+	{"PointerAlignment", mapClangFormatPointerAlignment},
+	{"BinPackArguments", nil},
+	{"BinPackParameters", nil},
+	{"AlignAfterOpenBracket", nil},
+	{"AlignConsecutiveDeclarations", clangFormatAlignConsecutive("AlignConsecutiveDeclarations", "align-declarations")},
+	{"AlignConsecutiveAssignments", clangFormatAlignConsecutive("AlignConsecutiveAssignments", "align-assignments", "align-enum-values")},
+	{"AlignConsecutiveMacros", clangFormatAlignConsecutive("AlignConsecutiveMacros", "align-macros")},
+	{"AlignTrailingComments", mapClangFormatAlignTrailingComments},
+	{"SpacesBeforeTrailingComments", clangFormatScalar("trailing-comment-gap")},
+	{"AlignConsecutiveBitFields", clangFormatAlignConsecutive("AlignConsecutiveBitFields", "align-struct-members")},
+	{"SortIncludes", mapClangFormatSortIncludes},
+	{"IncludeCategories", mapClangFormatIncludeCategories},
+	{"ReflowComments", mapClangFormatReflowComments},
+	{"BreakStringLiterals", clangFormatBool("BreakStringLiterals", "split-strings")},
+}
+
+var clangFormatStyles = map[string]string{
+	"llvm":   "llvm",
+	"gnu":    "gnu",
+	"webkit": "webkit",
+}
+
+func clangFormatScalar(name string) func(value *YamlNode) ([]Setting, error) {
+	return func(value *YamlNode) ([]Setting, error) {
+		return []Setting{{name, value.Value}}, nil
+	}
+}
+
+func clangFormatBool(key string, name string) func(value *YamlNode) ([]Setting, error) {
+	return func(value *YamlNode) ([]Setting, error) {
+		b, err := parseClangFormatBool(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+
+		return []Setting{{name, fmt.Sprint(b)}}, nil
+	}
+}
+
+func parseClangFormatBool(value *YamlNode) (bool, error) {
+	switch strings.ToLower(value.Value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean %s", value.Value)
+	}
+}
+
+func mapClangFormatUseTab(value *YamlNode) ([]Setting, error) {
+	switch value.Value {
+	case "Never", "false":
+		return []Setting{{"use-tabs", "false"}}, nil
+	case "ForIndentation", "Always", "true":
+		return []Setting{{"use-tabs", "true"}}, nil
+	default:
+		return []Setting{{"use-tabs", "true"}}, fmt.Errorf("UseTab: %s is treated as ForIndentation", value.Value)
+	}
+}
+
+func mapClangFormatIndentGotoLabels(value *YamlNode) ([]Setting, error) {
+	indent, err := parseClangFormatBool(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("IndentGotoLabels: %s", err)
+	}
+
+	if indent {
+		return []Setting{{"goto-label-indent", "none"}}, nil
+	}
+
+	return []Setting{{"goto-label-indent", "flush"}}, nil
+}
+
+func mapClangFormatIndentPPDirectives(value *YamlNode) ([]Setting, error) {
+	switch value.Value {
+	case "None":
+		return []Setting{{"directive-indent", "none"}}, nil
+	case "AfterHash":
+		return []Setting{{"directive-indent", "after-hash"}}, nil
+	case "BeforeHash":
+		return []Setting{{"directive-indent", "before-hash"}}, nil
+	default:
+		return nil, fmt.Errorf("IndentPPDirectives: unsupported value %s", value.Value)
+	}
+}
+
+func mapClangFormatPointerAlignment(value *YamlNode) ([]Setting, error) {
+	switch value.Value {
+	case "Left", "Right", "Middle":
+		return []Setting{{"pointer-alignment", strings.ToLower(value.Value)}}, nil
+	default:
+		return nil, fmt.Errorf("PointerAlignment: unsupported value %s", value.Value)
+	}
+}
+
+// AlignConsecutive options are either a value or a mapping of flags. Runs are always
+// broken by blank lines and comments. Enumerator values count as assignments, so
+// AlignConsecutiveAssignments sets more than one option.
+func clangFormatAlignConsecutive(key string, names ...string) func(value *YamlNode) ([]Setting, error) {
+	settings := func(b bool) []Setting {
+		result := []Setting{}
+
+		for _, name := range names {
+			result = append(result, Setting{name, fmt.Sprint(b)})
+		}
+
+		return result
+	}
+
+	return func(value *YamlNode) ([]Setting, error) {
+		if value.Kind == YamlKindMapping {
+			enabled := value.get("Enabled")
+
+			if enabled == nil {
+				return settings(false), nil
+			}
+
+			b, err := parseClangFormatBool(enabled)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+
+			for _, across := range []string{"AcrossEmptyLines", "AcrossComments"} {
+				if err == nil && value.get(across) != nil && value.get(across).Value == "true" {
+					err = fmt.Errorf("%s: %s is not supported", key, across)
+				}
+			}
+
+			return settings(b), err
+		}
+
+		switch value.Value {
+		case "None", "false":
+			return settings(false), nil
+		case "Consecutive", "true":
+			return settings(true), nil
+		case "AcrossEmptyLines", "AcrossComments", "AcrossEmptyLinesAndComments":
+			return settings(true), fmt.Errorf("%s: %s is treated as Consecutive", key, value.Value)
+		default:
+			return nil, fmt.Errorf("%s: unsupported value %s", key, value.Value)
+		}
+	}
+}
+
+// AlignTrailingComments is either a boolean or a mapping with a Kind
+func mapClangFormatAlignTrailingComments(value *YamlNode) ([]Setting, error) {
+	kind := value
+
+	if value.Kind == YamlKindMapping {
+		kind = value.get("Kind")
+
+		if kind == nil {
+			return nil, nil
+		}
+	}
+
+	switch kind.Value {
+	case "true", "Always":
+		return []Setting{{"align-trailing-comments", "true"}}, nil
+	case "false", "Never":
+		return []Setting{{"align-trailing-comments", "false"}}, nil
+	default:
+		return nil, fmt.Errorf("AlignTrailingComments: unsupported value %s", kind.Value)
+	}
+}
+
+// ReflowComments is a boolean, or Never, IndentOnly or Always since clang-format 20
+func mapClangFormatReflowComments(value *YamlNode) ([]Setting, error) {
+	switch value.Value {
+	case "Always":
+		return []Setting{{"reflow-comments", "true"}}, nil
+	case "Never", "IndentOnly":
+		return []Setting{{"reflow-comments", "false"}}, nil
+	default:
+		return clangFormatBool("ReflowComments", "reflow-comments")(value)
+	}
+}
+
+// SortIncludes is a boolean, a case sensitivity, or a mapping with Enabled and IgnoreCase
+func mapClangFormatSortIncludes(value *YamlNode) ([]Setting, error) {
+	if value.Kind == YamlKindMapping {
+		enabled := value.get("Enabled")
+
+		if enabled == nil {
+			return []Setting{{"sort-includes", "false"}}, nil
+		}
+
+		b, err := parseClangFormatBool(enabled)
+
+		if err != nil {
+			return nil, fmt.Errorf("SortIncludes: %s", err)
+		}
+
+		if ignoreCase := value.get("IgnoreCase"); b && ignoreCase != nil && ignoreCase.Value == "true" {
+			err = fmt.Errorf("SortIncludes: IgnoreCase is not supported")
+		}
+
+		return []Setting{{"sort-includes", fmt.Sprint(b)}}, err
+	}
+
+	switch value.Value {
+	case "Never", "false":
+		return []Setting{{"sort-includes", "false"}}, nil
+	case "CaseSensitive", "true":
+		return []Setting{{"sort-includes", "true"}}, nil
+	case "CaseInsensitive":
+		return []Setting{{"sort-includes", "true"}}, fmt.Errorf("SortIncludes: CaseInsensitive is treated as CaseSensitive")
+	default:
+		return nil, fmt.Errorf("SortIncludes: unsupported value %s", value.Value)
+	}
+}
+
+// IncludeCategories is a sequence of mappings with a Regex and a Priority. Categories
+// are ordered by priority, and headers matching none are sorted after them.
+func mapClangFormatIncludeCategories(value *YamlNode) ([]Setting, error) {
+	if value.Kind != YamlKindSequence {
+		return nil, fmt.Errorf("IncludeCategories: expected a sequence")
+	}
+
+	type category struct {
+		regex    string
+		priority int
+	}
+
+	categories := []category{}
+
+	for _, child := range value.Children {
+		regex := child.get("Regex")
+
+		if regex == nil {
+			return nil, fmt.Errorf("IncludeCategories: missing Regex")
+		}
+
+		if strings.ContainsAny(regex.Value, " \t") {
+			return nil, fmt.Errorf("IncludeCategories: %s: regular expressions with spaces are not supported", regex.Value)
+		}
+
+		priority := 0
+
+		if p := child.get("Priority"); p != nil {
+			var err error
+			priority, err = strconv.Atoi(p.Value)
+
+			if err != nil {
+				return nil, fmt.Errorf("IncludeCategories: invalid priority %s", p.Value)
+			}
+		}
+
+		categories = append(categories, category{regex.Value, priority})
+	}
+
+	var err error
+	compare := func(a category, b category) int {
+		return cmp.Compare(a.priority, b.priority)
+	}
+
+	// clang-format tries the categories in the order they are listed
+	if !slices.IsSortedFunc(categories, compare) {
+		slices.SortStableFunc(categories, compare)
+		err = fmt.Errorf("IncludeCategories: categories are matched in order of priority")
+	}
+
+	regexes := []string{}
+
+	for _, c := range categories {
+		regexes = append(regexes, c.regex)
+	}
+
+	return []Setting{{"include-categories", strings.Join(regexes, " ")}}, err
+}
+
+func braceSettings(function BraceStyle, control BraceStyle, types BraceStyle) []Setting {
+	return []Setting{
+		{"function-braces", function.String()},
+		{"control-braces", control.String()},
+		{"type-braces", types.String()},
+	}
+}
+
+func mapClangFormatBreakBeforeBraces(value *YamlNode) ([]Setting, error) {
+	switch value.Value {
+	case "Attach":
+		return braceSettings(BraceStyleAttach, BraceStyleAttach, BraceStyleAttach), nil
+	case "Linux", "Stroustrup", "WebKit":
+		return braceSettings(BraceStyleAllman, BraceStyleAttach, BraceStyleAttach), nil
+	case "Mozilla":
+		return braceSettings(BraceStyleAllman, BraceStyleAttach, BraceStyleAllman), nil
+	case "Allman":
+		return braceSettings(BraceStyleAllman, BraceStyleAllman, BraceStyleAllman), nil
+	case "GNU":
+		return braceSettings(BraceStyleAllman, BraceStyleGnu, BraceStyleAllman), nil
+	case "Whitesmiths":
+		return braceSettings(BraceStyleAllman, BraceStyleGnu, BraceStyleAllman),
+			fmt.Errorf("BreakBeforeBraces: Whitesmiths is treated as GNU")
+	case "Custom":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("BreakBeforeBraces: unsupported value %s", value.Value)
+	}
+}
+
+// Maps BraceWrapping, which is only used when BreakBeforeBraces is Custom
+func mapClangFormatBraceWrapping(wrapping *YamlNode) ([]Setting, []error) {
+	warnings := []error{}
+	wrapped := BraceStyleAllman
+
+	indentBraces := wrapping.get("IndentBraces")
+	if indentBraces != nil {
+		indent, err := parseClangFormatBool(indentBraces)
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("%d: BraceWrapping: %s", indentBraces.Line, err))
+		}
+		if indent {
+			wrapped = BraceStyleGnu
+		}
+	}
+
+	style := func(keys ...string) BraceStyle {
+		for _, key := range keys {
+			value := wrapping.get(key)
+
+			if value == nil {
+				continue
+			}
+
+			// AfterControlStatement can also be Never, MultiLine or Always
+			if value.Value == "Always" || value.Value == "MultiLine" {
+				return wrapped
+			}
+
+			if value.Value == "Never" {
+				continue
+			}
+
+			b, err := parseClangFormatBool(value)
+			if err != nil {
+				warnings = append(warnings, fmt.Errorf("%d: BraceWrapping: %s: %s", value.Line, key, err))
+			}
+			if b {
+				return wrapped
+			}
+		}
+		return BraceStyleAttach
+	}
+
+	settings := braceSettings(
+		style("AfterFunction"),
+		style("AfterControlStatement"),
+		style("AfterStruct", "AfterUnion", "AfterEnum"),
+	)
+
+	for _, key := range wrapping.Keys {
+		switch key {
+		case "AfterFunction", "AfterControlStatement", "AfterStruct", "AfterUnion", "AfterEnum", "IndentBraces":
+		default:
+			warnings = append(warnings, fmt.Errorf("%d: BraceWrapping: unsupported key %s", wrapping.get(key).Line, key))
+		}
+	}
+
+	return settings, warnings
+}
+
+// Maps BinPackArguments, BinPackParameters and AlignAfterOpenBracket, which together
+// decide how arguments and parameters are wrapped. All the supported styles bin-pack
+// them, and all but WebKit align them after the bracket.
+func mapClangFormatArgumentWrapping(document *YamlNode, style string) ([]Setting, []error) {
+	warnings := []error{}
+	align := document.get("AlignAfterOpenBracket")
+	isAligned := style != "webkit"
+
+	if align != nil {
+		switch align.Value {
+		case "Align":
+			isAligned = true
+		case "DontAlign", "AlwaysBreak", "BlockIndent":
+			isAligned = false
+		default:
+			warnings = append(warnings, fmt.Errorf("%d: AlignAfterOpenBracket: unsupported value %s", align.Line, align.Value))
+		}
+	}
+
+	settings := []Setting{}
+
+	keys := [...]struct {
+		Key    string
+		Option string
+	}{
+		{"BinPackArguments", "argument-wrapping"},
+		{"BinPackParameters", "parameter-wrapping"},
+	}
+
+	for _, key := range keys {
+		value := document.get(key.Key)
+
+		if value == nil && align == nil {
+			continue
+		}
+
+		binPack := true
+
+		if value != nil {
+			// BinPackParameters is BinPack, OnePerLine or AlwaysOnePerLine since clang-format 20
+			switch value.Value {
+			case "BinPack":
+			case "OnePerLine", "AlwaysOnePerLine":
+				binPack = false
+			default:
+				var err error
+				binPack, err = parseClangFormatBool(value)
+
+				if err != nil {
+					warnings = append(warnings, fmt.Errorf("%d: %s: %s", value.Line, key.Key, err))
+					continue
+				}
+			}
+		}
+
+		wrapping := ArgumentWrappingBinPack
+
+		switch {
+		case binPack && isAligned:
+			wrapping = ArgumentWrappingAlign
+		case !binPack:
+			wrapping = ArgumentWrappingOnePerLine
+
+			if isAligned && value != nil {
+				warnings = append(warnings, fmt.Errorf("%d: %s: one per line is indented instead of aligned", value.Line, key.Key))
+			}
+		}
+
+		settings = append(settings, Setting{key.Option, wrapping.String()})
+	}
+
+	return settings, warnings
+}
+
+func findClangFormatDocument(documents []*YamlNode) *YamlNode {
+	for _, document := range documents {
+		language := document.get("Language")
+
+		if language == nil || language.Value == "Cpp" {
+			return document
+		}
+	}
+
+	return nil
+}
+
+// Converts the settings of a .clang-format file that have an equivalent in cfmt.
+// Settings that are unsupported, or only approximated, are returned as warnings.
+func parseClangFormat(text string) ([]Setting, []error, error) {
+	documents, err := parseYaml(text)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	document := findClangFormatDocument(documents)
+
+	if document == nil {
+		return nil, nil, fmt.Errorf("no settings for C")
+	}
+
+	if document.Kind != YamlKindMapping {
+		return nil, nil, fmt.Errorf("%d: expected key: value", document.Line)
+	}
+
+	// Like clang-format, styles are based on LLVM by default
+	settings := []Setting{{"style", "llvm"}}
+	warnings := []error{}
+
+	basedOnStyle := document.get("BasedOnStyle")
+
+	if basedOnStyle != nil {
+		style, found := clangFormatStyles[strings.ToLower(basedOnStyle.Value)]
+
+		if found {
+			settings[0].Value = style
+		} else {
+			warnings = append(warnings, fmt.Errorf("%d: BasedOnStyle: unsupported style %s, using LLVM", basedOnStyle.Line, basedOnStyle.Value))
+		}
+	}
+
+	for i, key := range document.Keys {
+		value := document.Children[i]
+		mapped := false
+
+		for _, clangFormatKey := range clangFormatKeys {
+			if clangFormatKey.Name != key {
+				continue
+			}
+
+			mapped = true
+
+			if clangFormatKey.Map == nil {
+				break
+			}
+
+			keySettings, err := clangFormatKey.Map(value)
+
+			if err != nil {
+				warnings = append(warnings, fmt.Errorf("%d: %s", value.Line, err))
+			}
+
+			// Values cfmt cannot use keep the default of the style
+			for _, setting := range keySettings {
+				if applySetting(new(Options), setting) != nil {
+					warnings = append(warnings, clangFormatWarning(value, key))
+					continue
+				}
+
+				settings = append(settings, setting)
+			}
+		}
+
+		if !mapped {
+			warnings = append(warnings, fmt.Errorf("%d: unsupported key %s", value.Line, key))
+		}
+	}
+
+	breakBeforeBraces := document.get("BreakBeforeBraces")
+	wrapping := document.get("BraceWrapping")
+
+	if breakBeforeBraces != nil && breakBeforeBraces.Value == "Custom" && wrapping != nil {
+		wrappingSettings, wrappingWarnings := mapClangFormatBraceWrapping(wrapping)
+		settings = append(settings, wrappingSettings...)
+		warnings = append(warnings, wrappingWarnings...)
+	}
+
+	argumentSettings, argumentWarnings := mapClangFormatArgumentWrapping(document, settings[0].Value)
+	settings = append(settings, argumentSettings...)
+	warnings = append(warnings, argumentWarnings...)
+
+	return settings, warnings, nil
+}
+
+func clangFormatWarning(value *YamlNode, key string) error {
+	return fmt.Errorf("%d: %s: unsupported value %s", value.Line, key, value.Value)
+}
+
+func readClangFormat(path string) ([]Setting, []error, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings, warnings, err := parseClangFormat(string(data))
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s:%s", path, err)
+	}
+
+	for i, warning := range warnings {
+		warnings[i] = fmt.Errorf("%s:%s", path, warning)
+	}
+
+	return settings, warnings, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Runs of spaces inside a line, which line up the columns of tables and diagrams
+var alignedText = regexp.MustCompile(`\S {3,}\S`)
+
+// Whether a line of a block comment is a rule of *, = or - characters
+func isBannerLine(line string) bool {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "/*"), "*/"))
+
+	return len(line) >= 3 &&
+		(strings.Trim(line, "*") == "" || strings.Trim(line, "=") == "" || strings.Trim(line, "-") == "")
+}
+
+// Whether the lines of a block comment after the first start with *, as in Doxygen
+// comments and their likes. A last line holding only */ counts.
+func isStarredComment(content string) bool {
+	lines := strings.Split(content, "\n")
+
+	if len(lines) < 2 {
+		return false
+	}
+
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimSpace(line)
+
+		if len(trimmed) > 0 && !strings.HasPrefix(trimmed, "*") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether a block comment has a layout of its own to keep: Doxygen comments, comments
+// whose lines start with *, banners, and comments whose lines are indented differently
+// from each other or hold aligned text, such as diagrams, tables and code samples
+func isDecoratedComment(content string) bool {
+	if strings.HasPrefix(content, "/**") || strings.HasPrefix(content, "/*!") || isStarredComment(content) {
+		return true
+	}
+
+	lines := strings.Split(content, "\n")
+	indentation := -1
+
+	for i, line := range lines {
+		if isBannerLine(line) || alignedText.MatchString(line) {
+			return true
+		}
+
+		trimmed := strings.TrimSpace(line)
+
+		if i == 0 || len(trimmed) == 0 || trimmed == "*/" {
+			continue
+		}
+
+		lineIndentation := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		if indentation >= 0 && lineIndentation != indentation {
+			return true
+		}
+
+		indentation = lineIndentation
+	}
+
+	return false
+}
+
+// Returns the column of a token in the input, with tabs expanded
+func (f *Formatter) inputColumn(token Token) int {
+	start := 0
+
+	for line := 0; line < token.Line; line++ {
+		newLine := strings.IndexByte(f.Source[start:], '\n')
+
+		if newLine < 0 {
+			return token.Column
+		}
+
+		start += newLine + 1
+	}
+
+	return columnWidth(f.Source[start:min(start+token.Column, len(f.Source))], 0, f.Options.TabWidth)
+}
+
+// Returns the column after text, starting at the given column
+func columnWidth(text string, column int, tabWidth int) int {
+	for _, r := range text {
+		if r == '\t' {
+			column += tabWidth - column%tabWidth
+		} else {
+			column++
+		}
+	}
+
+	return column
+}
+
+// Returns whitespace that reaches the given column from the start of a line
+func (f *Formatter) indentationTo(column int) string {
+	if f.Options.UseTabs {
+		return strings.Repeat("\t", column/f.Options.TabWidth) + strings.Repeat(" ", column%f.Options.TabWidth)
+	}
+
+	return strings.Repeat(" ", column)
+}
+
+// Starts a line inside a block comment. Comments can span lines in directives without
+// a backslash.
+func (f *Formatter) writeCommentNewLine(indentation string) {
+	f.endAlignmentLine()
+	f.writeString(f.Options.EndOfLine.newLine())
+	f.OutputColumn = 0
+	f.writeString(indentation)
+}
+
+// Writes a decorated block comment, or any block comment when they are kept verbatim,
+// moving its lines by as many columns as its start moves. The * of starred comments
+// are lined up one column after the /, unless comments are verbatim.
+func (f *Formatter) formatDecoratedComment() {
+	lines := strings.Split(f.token().Content, "\n")
+	start := f.OutputColumn
+	shift := start - f.inputColumn(f.token())
+	isStarred := isStarredComment(f.token().Content) && !f.Options.VerbatimBlockComments
+
+	if isStarred && f.reflowsComments() {
+		lines = f.reflowStarredLines(lines, start)
+	}
+
+	// The comment keeps the indentation of the line it starts, tabs included
+	lineStart := strings.LastIndexByte(string(f.Output), '\n') + 1
+	indentation := string(f.Output[lineStart:])
+
+	if strings.TrimSpace(indentation) != "" {
+		indentation = f.indentationTo(start)
+	}
+
+	f.writeString(strings.TrimRight(lines[0], " \t\r"))
+
+	for _, line := range lines[1:] {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+
+		switch {
+		case len(trimmed) == 0:
+			f.writeCommentNewLine("")
+		case isStarred:
+			f.writeCommentNewLine(indentation + " ")
+			f.writeString(trimmed)
+		default:
+			column := columnWidth(line[:len(line)-len(trimmed)], 0, f.Options.TabWidth)
+			f.writeCommentNewLine(f.indentationTo(max(column+shift, 0)))
+			f.writeString(trimmed)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+type Conditional struct {
+	FirstToken     int
+	IsIncludeGuard bool
+	HasAlternative bool
+	Start          BranchState
+	FirstBranchEnd BranchState
+}
+
+// BranchState is the nesting state around a conditional directive. Each branch of an
+// #if/#elif/#else chain starts from the state before #if, and the chain continues
+// from the state at the end of its first branch, so that branches that open the same
+// brace differently (e.g. alternative function headers) do not unbalance the nodes.
+type BranchState struct {
+	Nodes               []Node
+	OpenNodeCount       [NodeTypeCount]int
+	Indent              int
+	OpenBraces          int
+	OpenParenthesis     int
+	AcceptStructOrUnion bool
+	AcceptEnum          bool
+}
+
+func (f *Formatter) updateConditionals() {
+	switch f.token().DirectiveType {
+	case DirectiveTypeIf, DirectiveTypeIfdef, DirectiveTypeIfndef:
+		f.DirectiveDepth = f.conditionalDepth()
+		f.Conditionals = append(f.Conditionals, Conditional{
+			FirstToken:     f.TokenIndex,
+			IsIncludeGuard: f.isIncludeGuardStart(),
+			Start:          f.branchState(),
+		})
+	case DirectiveTypeElif, DirectiveTypeElse:
+		f.DirectiveDepth = f.enclosingConditionalDepth()
+		if len(f.Conditionals) > 0 {
+			conditional := &f.Conditionals[len(f.Conditionals)-1]
+			if !conditional.HasAlternative {
+				conditional.HasAlternative = true
+				conditional.FirstBranchEnd = f.branchState()
+			}
+			f.restoreBranchState(conditional.Start)
+		}
+	case DirectiveTypeEndif:
+		f.DirectiveDepth = f.enclosingConditionalDepth()
+		if len(f.Conditionals) > 0 {
+			conditional := f.Conditionals[len(f.Conditionals)-1]
+			if conditional.HasAlternative {
+				f.restoreBranchState(conditional.FirstBranchEnd)
+			}
+			f.Conditionals = f.Conditionals[:len(f.Conditionals)-1]
+		}
+	default:
+		f.DirectiveDepth = f.conditionalDepth()
+	}
+}
+
+func (f *Formatter) conditionalDepth() int {
+	depth := 0
+
+	for _, conditional := range f.Conditionals {
+		if !conditional.IsIncludeGuard || f.Options.IndentIncludeGuard {
+			depth++
+		}
+	}
+
+	return depth
+}
+
+func (f *Formatter) enclosingConditionalDepth() int {
+	if len(f.Conditionals) == 0 {
+		return 0
+	}
+
+	depth := f.conditionalDepth()
+	innermost := f.Conditionals[len(f.Conditionals)-1]
+
+	if !innermost.IsIncludeGuard || f.Options.IndentIncludeGuard {
+		depth--
+	}
+
+	return depth
+}
+
+func (f *Formatter) isIncludeGuardStart() bool {
+	if f.token().DirectiveType != DirectiveTypeIfndef || len(f.Conditionals) > 0 {
+		return false
+	}
+
+	for i := 0; i < f.TokenIndex; i++ {
+		if !f.tokenAt(i).isComment() {
+			return false
+		}
+	}
+
+	name := f.tokenAt(f.TokenIndex + 1)
+
+	return name.isIdentifier() &&
+		f.tokenAt(f.TokenIndex+2).isDefine() &&
+		f.tokenAt(f.TokenIndex+3).Content == name.Content
+}
+
+func (f *Formatter) formatDirective() {
+	name := f.token().directiveName()
+	depth := f.DirectiveDepth
+
+	switch f.Options.DirectiveIndent {
+	case DirectiveIndentAfterHash:
+		f.writeString("#")
+		f.writeString(strings.Repeat(" ", depth*f.Options.IndentWidth))
+		f.writeString(name[1:])
+	case DirectiveIndentBeforeHash:
+		f.writeString(f.indentationTo(depth * f.Options.IndentWidth))
+		f.writeString(name)
+	default:
+		f.writeString(name)
+	}
+}
+
+// branchState must be called while the conditional directive is the current node,
+// which is left out of the state.
+func (f *Formatter) branchState() BranchState {
+	directive := f.Node()
+
+	result := BranchState{
+		Nodes:               slices.Clone(f.Nodes[:len(f.Nodes)-1]),
+		OpenNodeCount:       f.OpenNodeCount,
+		Indent:              directive.InitialIndent,
+		OpenBraces:          f.OpenBraces,
+		OpenParenthesis:     f.OpenParenthesis,
+		AcceptStructOrUnion: f.AcceptStructOrUnion,
+		AcceptEnum:          f.AcceptEnum,
+	}
+
+	result.OpenNodeCount[NodeTypeDirective]--
+
+	return result
+}
+
+func (f *Formatter) restoreBranchState(state BranchState) {
+	directive := *f.Node()
+	directive.InitialIndent = state.Indent
+	directive.InitialBraces = state.OpenBraces
+	directive.InitialParenthesis = state.OpenParenthesis
+
+	f.Nodes = append(slices.Clone(state.Nodes), directive)
+	f.OpenNodeCount = state.OpenNodeCount
+	f.OpenNodeCount[NodeTypeDirective]++
+	f.OpenBraces = state.OpenBraces
+	f.OpenParenthesis = state.OpenParenthesis
+	f.AcceptStructOrUnion = state.AcceptStructOrUnion
+	f.AcceptEnum = state.AcceptEnum
+}
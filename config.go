@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const CONFIG_FILE_NAME = ".cfmt"
+
+// A Setting assigns a value to the option with the given name, it can come from the
+// command line or from a configuration file
+type Setting struct {
+	Name  string
+	Value string
+}
+
+type OptionDefinition struct {
+	Name   string
+	Usage  string
+	IsBool bool
+	Set    func(options *Options, value string) error
+}
+
+type Preset struct {
+	Name    string
+	Options func() Options
+}
+
+var presets = [...]Preset{
+	{"cfmt", defaultOptions},
+	{"linux", linuxOptions},
+	{"llvm", llvmOptions},
+	{"gnu", gnuOptions},
+	{"webkit", webkitOptions},
+}
+
+var optionDefinitions = [...]OptionDefinition{
+	{"indent-width", "number of columns of an indentation level", false, func(o *Options, v string) error {
+		return parsePositiveInt(v, &o.IndentWidth)
+	}},
+	{"use-tabs", "indent with tabs instead of spaces", true, func(o *Options, v string) error {
+		return parseBool(v, &o.UseTabs)
+	}},
+	{"tab-width", "number of columns of a tab", false, func(o *Options, v string) error {
+		return parsePositiveInt(v, &o.TabWidth)
+	}},
+	{"column-limit", "maximum number of columns before wrapping, 0 for no limit", false, func(o *Options, v string) error {
+		return parseNonNegativeInt(v, &o.ColumnLimit)
+	}},
+	{"directive-indent", "indent nested directives: none, after-hash or before-hash", false, func(o *Options, v string) (err error) {
+		o.DirectiveIndent, err = parseDirectiveIndent(v)
+		return err
+	}},
+	{"indent-include-guard", "count the include guard when indenting directives", true, func(o *Options, v string) error {
+		return parseBool(v, &o.IndentIncludeGuard)
+	}},
+	{"indent-case-labels", "indent case labels inside switch statements", true, func(o *Options, v string) error {
+		return parseBool(v, &o.IndentCaseLabels)
+	}},
+	{"goto-label-indent", "indentation of goto labels: none, outdent or flush", false, func(o *Options, v string) (err error) {
+		o.GotoLabelIndent, err = parseLabelIndent(v)
+		return err
+	}},
+	{"insert-braces", "insert braces around single statement bodies of control statements", true, func(o *Options, v string) error {
+		return parseBool(v, &o.InsertBraces)
+	}},
+	{"function-braces", "brace placement for function definitions: attach, allman or gnu", false, func(o *Options, v string) (err error) {
+		o.FunctionBraces, err = parseBraceStyle(v)
+		return err
+	}},
+	{"control-braces", "brace placement for control statements: attach, allman or gnu", false, func(o *Options, v string) (err error) {
+		o.ControlBraces, err = parseBraceStyle(v)
+		return err
+	}},
+	{"type-braces", "brace placement for struct, union and enum: attach, allman or gnu", false, func(o *Options, v string) (err error) {
+		o.TypeBraces, err = parseBraceStyle(v)
+		return err
+	}},
+	{"initializer-braces", "brace placement for wrapped initializer lists: attach, allman or gnu", false, func(o *Options, v string) (err error) {
+		o.InitializerBraces, err = parseBraceStyle(v)
+		return err
+	}},
+	{"end-of-line", "line endings: lf, crlf or cr", false, func(o *Options, v string) (err error) {
+		o.EndOfLine, err = parseEndOfLine(v)
+		return err
+	}},
+	{"insert-final-newline", "end the file with a line ending", true, func(o *Options, v string) error {
+		return parseBool(v, &o.InsertFinalNewline)
+	}},
+	{"charset", "byte order mark: keep, utf-8 (without) or utf-8-bom (with)", false, func(o *Options, v string) (err error) {
+		o.Charset, err = parseCharset(v)
+		return err
+	}},
+	{"pointer-alignment", "placement of * and & in declarations: right (char *p), left (char* p) or middle (char * p)", false, func(o *Options, v string) (err error) {
+		o.PointerAlignment, err = parsePointerAlignment(v)
+		return err
+	}},
+	{"argument-wrapping", "arguments of calls that do not fit on one line: one-per-line, bin-pack or align (after the opening parenthesis)", false, func(o *Options, v string) (err error) {
+		o.ArgumentWrapping, err = parseArgumentWrapping(v)
+		return err
+	}},
+	{"parameter-wrapping", "parameters of definitions that do not fit on one line: one-per-line, bin-pack or align (after the opening parenthesis)", false, func(o *Options, v string) (err error) {
+		o.ParameterWrapping, err = parseArgumentWrapping(v)
+		return err
+	}},
+	{"align-declarations", "align the names of consecutive declarations", true, func(o *Options, v string) error {
+		return parseBool(v, &o.AlignDeclarations)
+	}},
+	{"align-assignments", "align the = of consecutive assignments and initializations", true, func(o *Options, v string) error {
+		return parseBool(v, &o.AlignAssignments)
+	}},
+	{"align-macros", "align the values of consecutive #define lines", true, func(o *Options, v string) error {
+		return parseBool(v, &o.AlignMacros)
+	}},
+	{"align-struct-members", "align the names, bit widths and trailing comments of struct and union members", true, func(o *Options, v string) error {
+		return parseBool(v, &o.AlignStructMembers)
+	}},
+	{"align-trailing-comments", "align trailing // comments of consecutive lines", true, func(o *Options, v string) error {
+		return parseBool(v, &o.AlignTrailingComments)
+	}},
+	{"trailing-comment-gap", "minimum number of spaces before trailing // comments", false, func(o *Options, v string) error {
+		return parsePositiveInt(v, &o.TrailingCommentGap)
+	}},
+	{"align-enum-values", "align the = and values of consecutive enumerators", true, func(o *Options, v string) error {
+		return parseBool(v, &o.AlignEnumValues)
+	}},
+	{"enum-trailing-comma", "comma after the last enumerator: keep, add or remove", false, func(o *Options, v string) (err error) {
+		o.EnumTrailingComma, err = parseTrailingComma(v)
+		return err
+	}},
+	{"sort-includes", "sort, group and deduplicate consecutive #include lines", true, func(o *Options, v string) error {
+		return parseBool(v, &o.SortIncludes)
+	}},
+	{"include-categories", "regular expressions, separated by spaces, matching the groups of included headers in order", false, func(o *Options, v string) error {
+		_, err := parseIncludeCategories(v)
+		o.IncludeCategories = v
+		return err
+	}},
+	{"deduplicate-pragmas", "remove repeated lines from blocks of consecutive #pragma lines", true, func(o *Options, v string) error {
+		return parseBool(v, &o.DeduplicatePragmas)
+	}},
+	{"sort-pragma-libraries", "sort consecutive #pragma comment(lib, ...) lines", true, func(o *Options, v string) error {
+		return parseBool(v, &o.SortPragmaLibraries)
+	}},
+	{"header-guard", "include guard of headers: keep, ifndef (renamed after the template) or pragma-once", false, func(o *Options, v string) (err error) {
+		o.HeaderGuard, err = parseHeaderGuard(v)
+		return err
+	}},
+	{"header-guard-template", "name of #ifndef include guards, with {PATH}, {DIR}, {FILE} and {EXT} placeholders", false, func(o *Options, v string) error {
+		_, err := headerGuardMacro(v, "dir/file.h")
+		o.HeaderGuardTemplate = v
+		return err
+	}},
+	{"verbatim-block-comments", "keep the lines of block comments as they are, apart from their indentation", true, func(o *Options, v string) error {
+		return parseBool(v, &o.VerbatimBlockComments)
+	}},
+	{"reflow-comments", "rewrap comment paragraphs to the column limit", true, func(o *Options, v string) error {
+		return parseBool(v, &o.ReflowComments)
+	}},
+	{"split-strings", "split string literals past the column limit into adjacent literals", true, func(o *Options, v string) error {
+		return parseBool(v, &o.SplitStrings)
+	}},
+	{"strict-width", "fail instead of warning when lines go past the column limit", true, func(o *Options, v string) error {
+		return parseBool(v, &o.StrictWidth)
+	}},
+}
+
+func linuxOptions() Options {
+	result := defaultOptions()
+	result.UseTabs = true
+	result.IndentWidth = 8
+	result.TabWidth = 8
+	result.ColumnLimit = 80
+	result.IndentCaseLabels = false
+	result.FunctionBraces = BraceStyleAllman
+	result.ArgumentWrapping = ArgumentWrappingAlign
+	result.ParameterWrapping = ArgumentWrappingAlign
+	return result
+}
+
+func llvmOptions() Options {
+	result := defaultOptions()
+	result.IndentWidth = 2
+	result.ColumnLimit = 80
+	result.IndentCaseLabels = false
+	result.ArgumentWrapping = ArgumentWrappingAlign
+	result.ParameterWrapping = ArgumentWrappingAlign
+	return result
+}
+
+func gnuOptions() Options {
+	result := defaultOptions()
+	result.IndentWidth = 2
+	result.ColumnLimit = 79
+	result.IndentCaseLabels = false
+	result.FunctionBraces = BraceStyleAllman
+	result.ControlBraces = BraceStyleGnu
+	result.TypeBraces = BraceStyleAllman
+	result.ArgumentWrapping = ArgumentWrappingAlign
+	result.ParameterWrapping = ArgumentWrappingAlign
+	return result
+}
+
+func webkitOptions() Options {
+	result := defaultOptions()
+	result.ColumnLimit = 0
+	result.IndentCaseLabels = false
+	result.FunctionBraces = BraceStyleAllman
+	result.PointerAlignment = PointerAlignmentLeft
+	return result
+}
+
+func presetOptions(name string) (Options, error) {
+	for _, preset := range presets {
+		if preset.Name == name {
+			return preset.Options(), nil
+		}
+	}
+
+	return Options{}, fmt.Errorf("invalid style: %s", name)
+}
+
+func parseBool(value string, result *bool) error {
+	b, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return fmt.Errorf("invalid boolean: %s", value)
+	}
+
+	*result = b
+	return nil
+}
+
+func parseNonNegativeInt(value string, result *int) error {
+	i, err := strconv.Atoi(value)
+
+	if err != nil || i < 0 {
+		return fmt.Errorf("invalid number: %s", value)
+	}
+
+	*result = i
+	return nil
+}
+
+func parsePositiveInt(value string, result *int) error {
+	i := 0
+	err := parseNonNegativeInt(value, &i)
+
+	if err != nil || i == 0 {
+		return fmt.Errorf("invalid number: %s", value)
+	}
+
+	*result = i
+	return nil
+}
+
+// The style setting selects the preset the other settings are applied to. If there
+// are several, the last one wins, so that the command line can override the
+// configuration file.
+func resolveOptions(settings []Setting) (Options, error) {
+	style := "cfmt"
+
+	for _, setting := range settings {
+		if setting.Name == "style" {
+			style = setting.Value
+		}
+	}
+
+	result, err := presetOptions(style)
+
+	if err != nil {
+		return result, err
+	}
+
+	for _, setting := range settings {
+		if setting.Name == "style" {
+			continue
+		}
+
+		err = applySetting(&result, setting)
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func applySetting(options *Options, setting Setting) error {
+	for _, definition := range optionDefinitions {
+		if definition.Name == setting.Name {
+			return definition.Set(options, setting.Value)
+		}
+	}
+
+	return fmt.Errorf("unknown option: %s", setting.Name)
+}
+
+// Reads lines of the form name = value. Empty lines and lines starting with # are ignored.
+func parseConfig(text string) ([]Setting, error) {
+	result := []Setting{}
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+
+		if !found {
+			return nil, fmt.Errorf("%d: expected name = value", i+1)
+		}
+
+		setting := Setting{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)}
+
+		if setting.Name == "style" {
+			_, err := presetOptions(setting.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", i+1, err)
+			}
+		} else {
+			err := applySetting(new(Options), setting)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", i+1, err)
+			}
+		}
+
+		result = append(result, setting)
+	}
+
+	return result, nil
+}
+
+// Returns the path of the configuration file closest to the given source file, or an
+// empty string if there is none. A .cfmt file takes precedence over a .clang-format
+// file in the same directory.
+func findConfig(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+
+	if err != nil {
+		return ""
+	}
+
+	for {
+		candidates := append([]string{CONFIG_FILE_NAME}, CLANG_FORMAT_FILE_NAMES[:]...)
+
+		for _, name := range candidates {
+			candidate := filepath.Join(dir, name)
+
+			info, err := os.Stat(candidate)
+			if err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func isClangFormatConfig(path string) bool {
+	for _, name := range CLANG_FORMAT_FILE_NAMES {
+		if filepath.Base(path) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readConfig(path string) ([]Setting, []error, error) {
+	if isClangFormatConfig(path) {
+		return readClangFormat(path)
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings, err := parseConfig(string(data))
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s:%s", path, err)
+	}
+
+	return settings, nil, nil
+}
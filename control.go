@@ -0,0 +1,268 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Returns the token before the parenthesis matching the one at index, e.g. the if
+// of if (...)
+func (f *Formatter) parenthesisOwner(index int) Token {
+	openParenthesis := 0
+
+	for i := index; i >= 0; i-- {
+		token := f.tokenAt(i)
+
+		if token.isRightParenthesis() {
+			openParenthesis++
+		}
+
+		if token.isLeftParenthesis() {
+			openParenthesis--
+		}
+
+		if openParenthesis == 0 {
+			return f.tokenAt(i - 1)
+		}
+	}
+
+	return Token{}
+}
+
+func (f *Formatter) isControlStatementHeaderEnd() bool {
+	if f.token().isRightParenthesis() {
+		owner := f.parenthesisOwner(f.TokenIndex)
+		return owner.isIf() || owner.isFor() || owner.isWhile()
+	}
+
+	return f.token().isElse() || f.token().isDo()
+}
+
+// Single statement bodies of if, else, for, while and do are treated as blocks
+// without braces, so that they can be indented
+func (f *Formatter) startsImplicitBlock() bool {
+	// Inline comments, as in if (x) /* why */ {, are skipped
+	index := f.TokenIndex + 1
+
+	for f.isInlineComment(index) {
+		index++
+	}
+
+	next := f.tokenAt(index)
+
+	return f.isControlStatementHeaderEnd() &&
+		!next.isLeftBrace() &&
+		!next.isSemicolon() &&
+		!next.isAbsent() &&
+		!(f.token().isElse() && next.isIf())
+}
+
+func (f *Formatter) pushImplicitBlock() {
+	blockType := BlockTypeNone
+
+	if f.token().isDo() {
+		blockType = BlockTypeDoWhile
+	} else if f.token().isRightParenthesis() && f.parenthesisOwner(f.TokenIndex).isIf() {
+		blockType = BlockTypeIf
+	}
+
+	f.pushNode(NodeTypeImplicitBlock)
+	f.Node().BlockType = blockType
+}
+
+// Ends the implicit block of the statement that has just been completed, and those
+// of enclosing statements that are completed with it
+func (f *Formatter) popImplicitBlocks() {
+	for f.Node().isImplicitBlock() {
+		node := *f.Node()
+		f.popNode()
+
+		if node.BlockType == BlockTypeDoWhile || (node.BlockType == BlockTypeIf && f.nextToken().isElse()) {
+			break
+		}
+	}
+}
+
+func (f *Formatter) endsImplicitBlock() bool {
+	if !f.Node().isImplicitBlock() {
+		return false
+	}
+
+	if f.token().isSemicolon() {
+		return f.isTopLevelInNode()
+	}
+
+	return f.token().isRightBrace() &&
+		f.LastPop.isBlock() &&
+		f.LastPop.LastToken == f.TokenIndex &&
+		f.LastPop.BlockType != BlockTypeDoWhile
+}
+
+func (f *Formatter) isImplicitBlockStart() bool {
+	return f.Node().isImplicitBlock() && f.isNodeStart()
+}
+
+func (f *Formatter) firstNonComment(index int) int {
+	for f.tokenAt(index).isComment() {
+		index++
+	}
+
+	return index
+}
+
+// Returns the index of the last token of the statement starting at index, or -1 if
+// the end cannot be determined reliably
+func (f *Formatter) statementEnd(index int) int {
+	index = f.firstNonComment(index)
+	token := f.tokenAt(index)
+
+	switch {
+	case token.isLeftBrace():
+		return f.matchingToken(index)
+	case token.isIf():
+		if !f.tokenAt(index + 1).isLeftParenthesis() {
+			return -1
+		}
+		end := f.statementEnd(f.matchingToken(index+1) + 1)
+		if end >= 0 && f.tokenAt(f.firstNonComment(end+1)).isElse() {
+			return f.statementEnd(f.firstNonComment(end+1) + 1)
+		}
+		return end
+	case token.isFor() || token.isWhile() || token.isSwitch():
+		if !f.tokenAt(index + 1).isLeftParenthesis() {
+			return -1
+		}
+		return f.statementEnd(f.matchingToken(index+1) + 1)
+	case token.isDo():
+		end := f.statementEnd(index + 1)
+		if end < 0 || !f.tokenAt(end+1).isWhile() {
+			return -1
+		}
+		end = f.matchingToken(end + 2)
+		if end < 0 || !f.tokenAt(end+1).isSemicolon() {
+			return -1
+		}
+		return end + 1
+	}
+
+	for i := index; ; i++ {
+		token := f.tokenAt(i)
+
+		if token.isAbsent() || token.isDirective() || token.isRightBrace() || token.isRightParenthesis() {
+			return -1
+		}
+
+		if token.isSemicolon() {
+			return i
+		}
+
+		if token.isLeftBracesBracketsOrParenthesis() {
+			i = f.matchingToken(i)
+			if i < 0 {
+				return -1
+			}
+		}
+	}
+}
+
+// Returns the index of the bracket, brace or parenthesis closing the one at index
+func (f *Formatter) matchingToken(index int) int {
+	if !f.tokenAt(index).isLeftBracesBracketsOrParenthesis() {
+		return -1
+	}
+
+	open := 0
+
+	for i := index; ; i++ {
+		token := f.tokenAt(i)
+
+		if token.isAbsent() {
+			return -1
+		}
+
+		if token.isLeftBracesBracketsOrParenthesis() {
+			open++
+		}
+
+		if token.isRightBracesBracketsOrParenthesis() {
+			open--
+		}
+
+		if open == 0 {
+			return i
+		}
+	}
+}
+
+func (f *Formatter) isMacroInvocation(index int) bool {
+	name := f.tokenAt(index)
+
+	if !name.isIdentifier() || !f.tokenAt(index+1).isLeftParenthesis() {
+		return false
+	}
+
+	end := f.matchingToken(index + 1)
+
+	return end < 0 || !f.tokenAt(end+1).isSemicolon() || strings.ToUpper(name.Content) == name.Content
+}
+
+// Returns false when braces around the body starting after the current token would
+// be ambiguous: bodies that are macro invocations, or that are interleaved with
+// directives
+func (f *Formatter) canInsertBraces() bool {
+	start := f.TokenIndex + 1
+	end := f.statementEnd(start)
+
+	if end < 0 || f.isMacroInvocation(f.firstNonComment(start)) {
+		return false
+	}
+
+	for i := start; i <= end; i++ {
+		if f.tokenAt(i).isDirective() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Formatter) insertBraces() {
+	start := f.TokenIndex + 1
+	end := f.statementEnd(start)
+
+	if f.tokenAt(end+1).isSingleLineComment() && !f.tokenAt(end).hasNewLines() {
+		end++
+	}
+
+	tokens := *f.Tokens
+	header := tokens[f.TokenIndex]
+	last := tokens[end]
+
+	leftBrace := Token{
+		Type:            TokenTypePunctuation,
+		PunctuationType: PunctuationTypeLeftBrace,
+		Content:         "{",
+		Whitespace:      header.Whitespace,
+		Line:            header.Line,
+		Column:          header.Column,
+		Inserted:        true,
+	}
+
+	rightBrace := Token{
+		Type:            TokenTypePunctuation,
+		PunctuationType: PunctuationTypeRightBrace,
+		Content:         "}",
+		Whitespace:      last.Whitespace,
+		Line:            last.Line,
+		Column:          last.Column,
+		Inserted:        true,
+	}
+
+	tokens[f.TokenIndex].Whitespace = Whitespace{HasSpace: true}
+	tokens[end].Whitespace = Whitespace{HasSpace: true, NewLines: 1, HasUnescapedLines: true}
+
+	tokens = slices.Insert(tokens, end+1, rightBrace)
+	tokens = slices.Insert(tokens, start, leftBrace)
+
+	*f.Tokens = tokens
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const EDITOR_CONFIG_FILE_NAME = ".editorconfig"
+
+type EditorConfigValue struct {
+	Value string
+	Path  string
+	Line  int
+}
+
+type EditorConfigSection struct {
+	Glob       EditorConfigGlob
+	Properties map[string]EditorConfigValue
+}
+
+// A section name compiled to a regular expression. The numbers matched by the numeric
+// ranges of the name, as {1..10}, are captured by the groups of the expression, and
+// checked against Ranges in order.
+type EditorConfigGlob struct {
+	Pattern *regexp.Regexp
+	Ranges  []EditorConfigRange
+}
+
+type EditorConfigRange struct {
+	Low  int
+	High int
+}
+
+type EditorConfig struct {
+	Root     bool
+	Sections []EditorConfigSection
+}
+
+var editorConfigNumericRange = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)$`)
+
+// Reads the sections of an .editorconfig file. Property names, and the values of the
+// properties cfmt uses, are case insensitive.
+func parseEditorConfig(text string, path string) (EditorConfig, error) {
+	result := EditorConfig{}
+	var section *EditorConfigSection
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			glob, err := compileEditorConfigGlob(line[1 : len(line)-1])
+
+			if err != nil {
+				return result, fmt.Errorf("%d: %s", i+1, err)
+			}
+
+			result.Sections = append(result.Sections, EditorConfigSection{glob, map[string]EditorConfigValue{}})
+			section = &result.Sections[len(result.Sections)-1]
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+
+		if !found {
+			return result, fmt.Errorf("%d: expected name = value", i+1)
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		if section == nil {
+			if name == "root" {
+				result.Root = value == "true"
+			}
+			continue
+		}
+
+		section.Properties[name] = EditorConfigValue{value, path, i + 1}
+	}
+
+	return result, nil
+}
+
+// Converts a section name to a regular expression matching paths relative to the
+// directory of the .editorconfig file. Names without a slash match files in any
+// subdirectory.
+func compileEditorConfigGlob(glob string) (EditorConfigGlob, error) {
+	prefix := "^"
+
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+	} else {
+		prefix += "(?:.*/)?"
+	}
+
+	result := EditorConfigGlob{}
+	pattern, err := regexp.Compile(prefix + editorConfigGlobRegex(glob, &result.Ranges) + "$")
+	result.Pattern = pattern
+
+	return result, err
+}
+
+// Whether the path matches the glob, with the numbers of its ranges within bounds
+func (g EditorConfigGlob) matches(path string) bool {
+	groups := g.Pattern.FindStringSubmatchIndex(path)
+
+	if groups == nil {
+		return false
+	}
+
+	for i, r := range g.Ranges {
+		start, end := groups[2*i+2], groups[2*i+3]
+
+		// The group is in an alternative that did not match
+		if start < 0 {
+			continue
+		}
+
+		n, err := strconv.Atoi(path[start:end])
+
+		if err != nil || n < r.Low || n > r.High {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Converts a glob to a regular expression, adding its numeric ranges to ranges
+func editorConfigGlobRegex(glob string, ranges *[]EditorConfigRange) string {
+	var result strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			result.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			// a/**/b also matches a/b
+			result.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			result.WriteString(".*")
+			i++
+		case c == '*':
+			result.WriteString("[^/]*")
+		case c == '?':
+			result.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+
+			if end < 0 || strings.Contains(glob[i+1:i+1+end], "/") {
+				result.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[i+1 : i+1+end]
+
+			if strings.HasPrefix(class, "!") {
+				result.WriteString("[^/" + escapeCharacterClass(class[1:]) + "]")
+			} else {
+				result.WriteString("[" + escapeCharacterClass(class) + "]")
+			}
+
+			i += end + 1
+		case c == '{':
+			end := matchingGlobBrace(glob, i)
+
+			if end < 0 {
+				result.WriteString(`\{`)
+				continue
+			}
+
+			result.WriteString(editorConfigBraceRegex(glob[i+1:end], ranges))
+			i = end
+		default:
+			result.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return result.String()
+}
+
+// Escapes the characters of a [...] class, keeping ranges
+func escapeCharacterClass(class string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `^`, `\^`).Replace(class)
+}
+
+func matchingGlobBrace(glob string, start int) int {
+	depth := 0
+
+	for i := start; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Converts the content of {s1,s2} or {n1..n2}. A range matches any integer, which is
+// checked against its bounds once the path matches. Braces without a comma or a range
+// are matched literally.
+func editorConfigBraceRegex(content string, ranges *[]EditorConfigRange) string {
+	if numbers := editorConfigNumericRange.FindStringSubmatch(content); numbers != nil {
+		low, lowErr := strconv.Atoi(numbers[1])
+		high, highErr := strconv.Atoi(numbers[2])
+
+		if lowErr == nil && highErr == nil {
+			*ranges = append(*ranges, EditorConfigRange{min(low, high), max(low, high)})
+			return `([+-]?\d+)`
+		}
+	}
+
+	alternatives := []string{}
+	depth := 0
+	start := 0
+
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, editorConfigGlobRegex(content[start:i], ranges))
+				start = i + 1
+			}
+		}
+	}
+
+	if len(alternatives) == 0 {
+		return `\{` + editorConfigGlobRegex(content, ranges) + `\}`
+	}
+
+	alternatives = append(alternatives, editorConfigGlobRegex(content[start:], ranges))
+
+	return "(?:" + strings.Join(alternatives, "|") + ")"
+}
+
+// Returns the properties applying to the given source file. Files closer to it take
+// precedence, and so do later sections in the same file. The search stops at a file
+// with root = true.
+func editorConfigProperties(path string) (map[string]EditorConfigValue, error) {
+	path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []EditorConfig{}
+	dirs := []string{}
+	dir := filepath.Dir(path)
+
+	for {
+		candidate := filepath.Join(dir, EDITOR_CONFIG_FILE_NAME)
+		data, err := os.ReadFile(candidate)
+
+		if err == nil {
+			config, err := parseEditorConfig(string(data), candidate)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s", candidate, err)
+			}
+
+			configs = append(configs, config)
+			dirs = append(dirs, dir)
+
+			if config.Root {
+				break
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	result := map[string]EditorConfigValue{}
+
+	for i := len(configs) - 1; i >= 0; i-- {
+		relative, err := filepath.Rel(dirs[i], path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, section := range configs[i].Sections {
+			if !section.Glob.matches(filepath.ToSlash(relative)) {
+				continue
+			}
+
+			for name, value := range section.Properties {
+				result[name] = value
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func editorConfigWarning(value EditorConfigValue, name string) error {
+	return fmt.Errorf("%s:%d: %s: unsupported value %s", value.Path, value.Line, name, value.Value)
+}
+
+// Converts EditorConfig properties to settings. Properties set to unset are ignored,
+// as are properties that do not concern formatting.
+func editorConfigSettings(properties map[string]EditorConfigValue) ([]Setting, []error) {
+	settings := []Setting{}
+	warnings := []error{}
+
+	for name, value := range properties {
+		if value.Value == "unset" {
+			delete(properties, name)
+		}
+	}
+
+	add := func(option string, property string, value string) {
+		setting := Setting{option, value}
+
+		if applySetting(new(Options), setting) != nil {
+			warnings = append(warnings, editorConfigWarning(properties[property], property))
+			return
+		}
+
+		settings = append(settings, setting)
+	}
+
+	if indentStyle, found := properties["indent_style"]; found {
+		switch indentStyle.Value {
+		case "tab":
+			add("use-tabs", "indent_style", "true")
+		case "space":
+			add("use-tabs", "indent_style", "false")
+		default:
+			warnings = append(warnings, editorConfigWarning(indentStyle, "indent_style"))
+		}
+	}
+
+	tabWidth, hasTabWidth := properties["tab_width"]
+	indentSize, hasIndentSize := properties["indent_size"]
+
+	// indent_size = tab uses tab_width, and tab_width defaults to indent_size
+	if hasIndentSize && indentSize.Value == "tab" {
+		if hasTabWidth {
+			add("indent-width", "tab_width", tabWidth.Value)
+		}
+	} else if hasIndentSize {
+		add("indent-width", "indent_size", indentSize.Value)
+	}
+
+	if hasTabWidth {
+		add("tab-width", "tab_width", tabWidth.Value)
+	} else if hasIndentSize && indentSize.Value != "tab" {
+		add("tab-width", "indent_size", indentSize.Value)
+	}
+
+	if _, found := properties["end_of_line"]; found {
+		add("end-of-line", "end_of_line", properties["end_of_line"].Value)
+	}
+
+	if _, found := properties["insert_final_newline"]; found {
+		add("insert-final-newline", "insert_final_newline", properties["insert_final_newline"].Value)
+	}
+
+	if maxLineLength, found := properties["max_line_length"]; found {
+		if maxLineLength.Value == "off" {
+			add("column-limit", "max_line_length", "0")
+		} else {
+			add("column-limit", "max_line_length", maxLineLength.Value)
+		}
+	}
+
+	if charset, found := properties["charset"]; found {
+		switch charset.Value {
+		case "utf-8", "utf-8-bom":
+			add("charset", "charset", charset.Value)
+		default:
+			warnings = append(warnings, editorConfigWarning(charset, "charset"))
+		}
+	}
+
+	return settings, warnings
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+type TrailingComma int
+
+const (
+	TrailingCommaKeep TrailingComma = iota
+	TrailingCommaAdd
+	TrailingCommaRemove
+)
+
+type TrailingCommaName struct {
+	Name          string
+	TrailingComma TrailingComma
+}
+
+var trailingCommaNames = [...]TrailingCommaName{
+	{"keep", TrailingCommaKeep},
+	{"add", TrailingCommaAdd},
+	{"remove", TrailingCommaRemove},
+}
+
+func parseTrailingComma(name string) (TrailingComma, error) {
+	for _, n := range trailingCommaNames {
+		if n.Name == name {
+			return n.TrailingComma, nil
+		}
+	}
+
+	return TrailingCommaKeep, fmt.Errorf("invalid trailing comma: %s", name)
+}
+
+func (t TrailingComma) String() string {
+	for _, n := range trailingCommaNames {
+		if n.TrailingComma == t {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected trailing comma %d", t))
+}
+
+func (f *Formatter) isEnumValueAssignment() bool {
+	return f.Node().isEnum() &&
+		f.token().isPlainAssignment() &&
+		f.OpenParenthesis == f.Node().InitialParenthesis
+}
+
+// Whether the current token ends the last enumerator of an enum body, not counting
+// its trailing comma
+func (f *Formatter) isLastEnumeratorEnd() bool {
+	if !f.Node().isEnum() ||
+		f.OpenParenthesis != f.Node().InitialParenthesis ||
+		f.token().isLeftBrace() ||
+		f.token().isComma() ||
+		f.token().isComment() {
+		return false
+	}
+
+	next := f.firstNonComment(f.TokenIndex + 1)
+
+	if f.tokenAt(next).isComma() {
+		next = f.firstNonComment(next + 1)
+	}
+
+	return f.tokenAt(next).isRightBrace()
+}
+
+// Adds or removes the comma after the last enumerator. Whitespace moves with the
+// comma, so that a trailing comment stays on the line of the enumerator.
+func (f *Formatter) updateEnumTrailingComma() {
+	if f.Options.EnumTrailingComma == TrailingCommaKeep || !f.isLastEnumeratorEnd() {
+		return
+	}
+
+	tokens := *f.Tokens
+	last := tokens[f.TokenIndex]
+	hasComma := f.nextToken().isComma()
+
+	if f.Options.EnumTrailingComma == TrailingCommaAdd && !hasComma {
+		comma := Token{
+			Type:            TokenTypePunctuation,
+			PunctuationType: PunctuationTypeComma,
+			Content:         ",",
+			Whitespace:      last.Whitespace,
+			Line:            last.Line,
+			Column:          last.Column,
+			Inserted:        true,
+		}
+
+		tokens[f.TokenIndex].Whitespace = Whitespace{}
+		tokens = slices.Insert(tokens, f.TokenIndex+1, comma)
+	}
+
+	if f.Options.EnumTrailingComma == TrailingCommaRemove && hasComma {
+		tokens[f.TokenIndex].Whitespace = tokens[f.TokenIndex+1].Whitespace
+		tokens = slices.Delete(tokens, f.TokenIndex+1, f.TokenIndex+2)
+	}
+
+	*f.Tokens = tokens
+}
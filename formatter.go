@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"regexp"
 	"slices"
 	"strings"
 	"unicode/utf8"
@@ -22,16 +23,35 @@ type Formatter struct {
 	Nodes               []Node
 	LastNodeId          int
 	LastPop             Node
-	WrappingNode        int
 	OpenBraces          int
-	Wrapping            bool
+	Measuring           bool
+	Measured            []LayoutToken
+	Layout              *Layout
 	Tokens              *[]Token
 	OpenNodeCount       [NodeTypeCount]int
-}
-
-type SavedState struct {
-	Formatter Formatter
-	Nodes     []Node
+	Conditionals        []Conditional
+	DirectiveDepth      int
+	NextBraceStyle      BraceStyle
+	Declarator          int
+	DeclaratorName      int
+	AssignmentAligned   bool
+	AlignmentPoints     []AlignmentPoint
+	IncludeCategories   []*regexp.Regexp
+	Path                string
+	Source              string
+	IncludeGuard        IncludeGuard
+	HeaderGuardMacro    string
+	LongLines           []LongLine
+	Options             Options
+}
+
+type StatementStart struct {
+	Formatter    Formatter
+	Nodes        []Node
+	Conditionals []Conditional
+	// Alignment points of the line the statement starts on, which are changed in place
+	// when the line ends or wraps
+	LinePoints []AlignmentPoint
 }
 
 const MAX_COLUMNS int = 110
@@ -48,25 +68,35 @@ func (f *Formatter) nextToken() Token {
 	return f.tokenAt(f.TokenIndex + 1)
 }
 
-func (f *Formatter) shouldWrap() bool {
-	return f.OutputColumn > MAX_COLUMNS ||
+func (f *Formatter) needsLayout() bool {
+	return (f.Options.ColumnLimit > 0 && f.OutputColumn > f.Options.ColumnLimit) ||
 		((f.Node().isInitializerList() || f.Node().isFuncOrMacro()) &&
-			(f.nextToken().isComment() || f.nextToken().isDirective())) ||
+			((f.nextToken().isComment() && !f.isInlineComment(f.TokenIndex+1)) || f.nextToken().isDirective())) ||
 		(f.isInsideFuncOrMacro() && f.Node().isBlock())
 
 }
 
-func (f *Formatter) save() SavedState {
-	result := SavedState{}
+func (f *Formatter) save() StatementStart {
+	result := StatementStart{}
 	result.Formatter = *f
 	result.Nodes = slices.Clone(f.Nodes)
+	result.Conditionals = slices.Clone(f.Conditionals)
+	first := len(f.AlignmentPoints)
+
+	for first > 0 && f.AlignmentPoints[first-1].Line == f.OutputLine {
+		first--
+	}
+
+	result.LinePoints = slices.Clone(f.AlignmentPoints[first:])
 
 	return result
 }
 
-func (f *Formatter) restore(savedState *SavedState) {
-	*f = savedState.Formatter
-	f.Nodes = slices.Clone(savedState.Nodes)
+func (f *Formatter) restore(start *StatementStart) {
+	*f = start.Formatter
+	f.Nodes = slices.Clone(start.Nodes)
+	f.Conditionals = slices.Clone(start.Conditionals)
+	copy(f.AlignmentPoints[len(f.AlignmentPoints)-len(start.LinePoints):], start.LinePoints)
 }
 
 func (f *Formatter) isMacroDefName() bool {
@@ -74,25 +104,84 @@ func (f *Formatter) isMacroDefName() bool {
 }
 
 func Format(input string) (string, error) {
+	return FormatWithOptions(input, defaultOptions())
+}
+
+func FormatWithOptions(input string, options Options) (string, error) {
+	return FormatWithPath(input, "", options)
+}
 
-	f := Formatter{Input: &input, Tokens: new([]Token), InputLine: new(int), InputColumn: new(int)}
+// Formats the content of the file at path, which is only used to recognize its main
+// header when sorting includes
+func FormatWithPath(input string, path string, options Options) (string, error) {
+	output, _, err := formatWithWarnings(input, path, options)
+	return output, err
+}
+
+// Formats the content of the file at path, and returns a warning for each line of the
+// output that goes past the column limit
+func formatWithWarnings(input string, path string, options Options) (string, []error, error) {
+	includeCategories, err := parseIncludeCategories(options.IncludeCategories)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	hasByteOrderMark := strings.HasPrefix(input, BYTE_ORDER_MARK)
+	input = strings.TrimPrefix(input, BYTE_ORDER_MARK)
+
+	f := Formatter{
+		Input:             &input,
+		Tokens:            new([]Token),
+		InputLine:         new(int),
+		InputColumn:       new(int),
+		Declarator:        -1,
+		DeclaratorName:    -1,
+		IncludeCategories: includeCategories,
+		Path:              path,
+		Source:            input,
+		Options:           options,
+	}
+
+	if options.HeaderGuard != HeaderGuardKeep && isHeaderPath(path) {
+		f.IncludeGuard = findIncludeGuard(lexTokens(input))
+		f.HeaderGuardMacro, err = headerGuardMacro(options.HeaderGuardTemplate, path)
+
+		if err != nil {
+			return "", nil, err
+		}
+	}
 
 	(&f).pushNode(NodeTypeTopLevel)
-	saved := f.save()
+	start := f.save()
 
 	_ = f.skipSpaceAndCountNewLines()
-	for f.update() {
+	for {
+		if !f.update() {
+			if !f.Measuring {
+				break
+			}
+
+			f.replayWithLayout(&start)
+			f.TokenIndex++
+			continue
+		}
+
 		if f.token().isInvalid() {
-			return "", fmt.Errorf("%d:%d invalid token", f.token().Line+1, f.token().Column+1)
+			return "", nil, fmt.Errorf("%d:%d invalid token", f.token().Line+1, f.token().Column+1)
 		}
 
 		//fmt.Printf("%s\n", f.token())
 
+		f.updateAlignment()
+		column, length := f.OutputColumn, len(f.Output)
 		f.formatToken()
+		f.recordLongLine()
+		f.measureToken(column, length)
 
-		if !f.Wrapping && f.shouldWrap() && f.TokenIndex > 0 {
-			f.restore(&saved)
-			f.Wrapping = true
+		if f.Layout == nil && !f.Measuring && f.needsLayout() && f.TokenIndex > 0 {
+			f.restore(&start)
+			f.Measuring = true
 		} else {
 			if f.isMacroDefName() && !f.nextToken().isLeftParenthesis() {
 				f.writeString(" ")
@@ -100,10 +189,21 @@ func Format(input string) (string, error) {
 				f.writeNewLines(1)
 			} else if f.isEndOfDirective() || f.alwaysDefaultLines() {
 				f.writeDefaultLines()
-			} else if f.indentedWrapping() {
+			} else if f.breaksLine() {
+				f.wrapAlignmentLine()
+				f.writeNewLines(1)
+			} else if f.continuesString() && (f.Measuring || f.Layout != nil) {
+				f.wrapAlignmentLine()
+				f.writeNewLines(1)
+			} else if f.continuesString() {
+				f.wrapAlignmentLine()
 				f.Indent++
 				f.writeNewLines(1)
 				f.Indent--
+			} else if f.NextBraceStyle = f.nextBraceStyle(); f.NextBraceStyle != BraceStyleAttach {
+				f.writeBeforeBrace()
+			} else if f.hasTrailingComment() {
+				f.writeString(strings.Repeat(" ", f.Options.TrailingCommentGap))
 			} else if !f.neverSpace() &&
 				!f.nextToken().isRightBrace() &&
 				!f.token().isLeftBrace() {
@@ -111,14 +211,17 @@ func Format(input string) (string, error) {
 			}
 
 			if f.TokenIndex == 0 {
-				saved = f.save()
+				start = f.save()
 			}
 
 			if !f.isInsideFuncOrMacro() {
-				if (f.isBlockStart()) || ((!f.Node().isStructOrUnion() && !f.Node().isDirective()) && f.token().isSemicolon()) {
-					f.Wrapping = false
-					f.WrappingNode = 0
-					saved = f.save()
+				if f.isBlockStart() || f.endsStatement() {
+					if f.Measuring {
+						f.replayWithLayout(&start)
+					} else {
+						f.Layout = nil
+						start = f.save()
+					}
 				}
 			}
 		}
@@ -132,11 +235,38 @@ func Format(input string) (string, error) {
 
 		if !node.isTopLevel() && !node.isDirective() {
 			firstToken := (*f.Tokens)[node.FirstToken]
-			return "", fmt.Errorf("%d:%d unclosed node %s", firstToken.Line+1, firstToken.Column+1, node.Type)
+			return "", nil, fmt.Errorf("%d:%d unclosed node %s", firstToken.Line+1, firstToken.Column+1, node.Type)
+		}
+	}
+
+	f.Output = insertPadding(f.Output, alignmentPadding(f.AlignmentPoints, f.Options.ColumnLimit))
+
+	if f.Options.InsertBraces {
+		var input []Token
+
+		if !rewritesTokens(f.Options) {
+			input = tokenize(f.Source)
+		}
+
+		err := checkTokens(input, *f.Tokens, string(f.Output))
+
+		if err != nil {
+			return "", nil, err
 		}
 	}
 
-	return string(f.Output), nil
+	output := string(f.Output)
+	warnings := longLineWarnings(output, f.LongLines, f.Options)
+
+	if !f.Options.InsertFinalNewline {
+		output = strings.TrimSuffix(output, f.Options.EndOfLine.newLine())
+	}
+
+	if f.Options.Charset == CharsetUtf8Bom || (f.Options.Charset == CharsetKeep && hasByteOrderMark) {
+		output = BYTE_ORDER_MARK + output
+	}
+
+	return output, warnings, nil
 }
 
 func (f *Formatter) tokenAt(index int) Token {
@@ -150,7 +280,13 @@ func (f *Formatter) tokenAt(index int) Token {
 		token.Line = *f.InputLine
 		token.Column = *f.InputColumn
 		*f.Input = (*f.Input)[len(token.Content):]
-		*f.InputColumn += len(token.Content)
+
+		if newLines := strings.Count(token.Content, "\n"); newLines > 0 {
+			*f.InputLine += newLines
+			*f.InputColumn = len(token.Content) - strings.LastIndexByte(token.Content, '\n') - 1
+		} else {
+			*f.InputColumn += len(token.Content)
+		}
 		token.Whitespace = f.skipSpaceAndCountNewLines()
 		(*f.Tokens) = append(*f.Tokens, token)
 
@@ -160,6 +296,11 @@ func (f *Formatter) tokenAt(index int) Token {
 }
 
 func (f *Formatter) update() bool {
+	f.updateHeaderGuard()
+	f.sortIncludes()
+	f.updatePragmas()
+	f.reflowCommentLines()
+	f.splitString()
 
 	if f.token().isStructOrUnion() {
 		f.AcceptStructOrUnion = true
@@ -180,6 +321,8 @@ func (f *Formatter) update() bool {
 
 	if f.token().isSemicolon() && f.isTopLevelInNode() {
 		f.Node().RightSideOfAssignment = false
+		f.AcceptStructOrUnion = false
+		f.AcceptEnum = false
 	}
 
 	if f.token().isLeftParenthesis() {
@@ -194,6 +337,7 @@ func (f *Formatter) update() bool {
 
 		if f.token().isDirective() {
 			f.pushNode(NodeTypeDirective)
+			f.updateConditionals()
 		} else if f.startsFuncOrMacroDef() {
 			f.pushNode(NodeTypeFuncOrMacroDef)
 
@@ -204,7 +348,9 @@ func (f *Formatter) update() bool {
 			f.pushNode(NodeTypeForLoopParenthesis)
 
 		} else if f.token().isLeftBrace() {
-			if f.previousToken().isAssignment() || f.Node().isInitializerList() {
+			if f.isLinkageSpecificationStart() {
+				f.pushNode(NodeTypeLinkageSpecification)
+			} else if f.previousToken().isAssignment() || f.Node().isInitializerList() {
 				f.pushNode(NodeTypeInitializerList)
 			} else if f.AcceptStructOrUnion || f.Node().isStructOrUnion() {
 				f.pushNode(NodeTypeStructOrUnion)
@@ -212,6 +358,9 @@ func (f *Formatter) update() bool {
 			} else if f.AcceptEnum {
 				f.pushNode(NodeTypeEnum)
 
+			} else if f.isSwitchBodyStart() {
+				f.pushNode(NodeTypeSwitch)
+
 			} else {
 				f.pushNode(NodeTypeBlock)
 
@@ -220,9 +369,11 @@ func (f *Formatter) update() bool {
 	}
 
 	if (f.Node().Type == NodeTypeBlock ||
+		f.Node().Type == NodeTypeSwitch ||
 		f.Node().Type == NodeTypeInitializerList ||
 		f.Node().Type == NodeTypeEnum ||
-		f.Node().Type == NodeTypeStructOrUnion) &&
+		f.Node().Type == NodeTypeStructOrUnion ||
+		f.Node().Type == NodeTypeLinkageSpecification) &&
 		f.token().isRightBrace() {
 		f.popNode()
 	}
@@ -236,16 +387,6 @@ func (f *Formatter) update() bool {
 		f.popNode()
 	}
 
-	if f.Wrapping && f.WrappingNode == 0 {
-		if f.isFunctionStart() && (!f.isRightSideOfAssignment() || f.functionIsEntireRightSide()) {
-			f.WrappingNode = f.Node().Id
-		} else if f.isInitializerListStart() {
-			f.WrappingNode = f.Node().Id
-		} else if (f.Node().isTopLevel() || f.Node().isBlock()) && f.Node().RightSideOfAssignment && !f.isFunctionName() {
-			f.WrappingNode = f.Node().Id
-		}
-	}
-
 	if f.token().isRightParenthesis() {
 		f.OpenParenthesis--
 
@@ -253,12 +394,27 @@ func (f *Formatter) update() bool {
 
 	if f.token().isRightBrace() {
 		f.OpenBraces--
-		if f.OpenBraces == 0 {
+		if f.OpenBraces == 0 || f.Node().isLinkageSpecification() {
 			f.AcceptEnum = false
 			f.AcceptStructOrUnion = false
 		}
 	}
 
+	if f.endsImplicitBlock() {
+		f.popImplicitBlocks()
+	}
+
+	// The ) of a do { ... } while (0) macro body ends the directive, not a statement header
+	if !f.Node().isDirective() && !f.isEndOfDirective() && f.startsImplicitBlock() {
+		if f.Options.InsertBraces && f.canInsertBraces() {
+			f.insertBraces()
+		} else {
+			f.pushImplicitBlock()
+		}
+	}
+
+	f.updateEnumTrailingComma()
+
 	if f.Node().isDirective() {
 		if f.token().hasEscapedLines() {
 			if f.token().isLeftBrace() || f.token().isLeftParenthesis() {
@@ -275,6 +431,18 @@ func (f *Formatter) update() bool {
 		f.Indent++
 	}
 
+	if f.token().isLeftBrace() && f.isNodeStart() {
+		f.Node().BraceStyle = f.NextBraceStyle
+
+		if f.Node().BraceStyle == BraceStyleGnu {
+			f.Indent++
+		}
+	}
+
+	f.NextBraceStyle = BraceStyleAttach
+
+	f.updateCaseLabels()
+
 	if f.shouldDecreaseIndent() {
 		f.Indent--
 	}
@@ -282,15 +450,19 @@ func (f *Formatter) update() bool {
 	return !f.token().isAbsent()
 }
 
+func (f *Formatter) indentsBody() bool {
+	return f.Node().isStructOrUnion() ||
+		f.Node().isEnum() ||
+		(f.Node().isBlock() && (!f.Node().isSwitch() || f.Options.IndentCaseLabels))
+}
+
 func (f *Formatter) shouldIncreaseIndent() bool {
-	return ((f.Node().isStructOrUnion() || f.Node().isBlock() || f.Node().isEnum()) && f.isNodeStart()) ||
-		(f.Wrapping && f.isWrappingNode() && (f.isInitializerListStart() || f.isFuncOrMacroStart()))
+	return (f.indentsBody() && f.isNodeStart()) ||
+		f.isImplicitBlockStart()
 }
 
 func (f *Formatter) shouldDecreaseIndent() bool {
-	return ((f.Node().isStructOrUnion() || f.Node().isBlock() || f.Node().isEnum()) && f.nextToken().isRightBrace()) ||
-		(f.Wrapping && f.isWrappingNode() && f.Node().isInitializerList() && f.nextToken().isRightBrace()) ||
-		(f.Wrapping && f.isWrappingNode() && f.beforeEndOfFuncOrMacro())
+	return f.indentsBody() && f.nextToken().isRightBrace()
 }
 
 func (f *Formatter) skipSpaceAndCountNewLines() Whitespace {
@@ -346,7 +518,15 @@ func (f *Formatter) consumeSpace(Whitespace *Whitespace) bool {
 
 func (formatter *Formatter) writeString(str string) {
 	formatter.Output = append(formatter.Output, []byte(str)...)
-	formatter.OutputColumn += len(str)
+	formatter.OutputLine += strings.Count(str, formatter.Options.EndOfLine.newLine())
+
+	for i := 0; i < len(str); i++ {
+		if str[i] == '\t' {
+			formatter.OutputColumn += formatter.Options.TabWidth - formatter.OutputColumn%formatter.Options.TabWidth
+		} else {
+			formatter.OutputColumn++
+		}
+	}
 }
 
 func (formatter *Formatter) oneOrTwoLines() {
@@ -371,30 +551,40 @@ func (f *Formatter) isEndOfDirective() bool {
 }
 
 func (formatter *Formatter) writeNewLines(lines int) {
-	const newLine = "\n"
+	newLine := formatter.Options.EndOfLine.newLine()
 
 	for line := 0; line < lines; line++ {
 		if formatter.Node().isDirective() {
 			formatter.writeString("\\")
 		}
+		formatter.endAlignmentLine()
 		formatter.writeString(newLine)
 		formatter.OutputColumn = 0
-		formatter.OutputLine++
 	}
 
-	const indentation = "    "
-	if !formatter.nextToken().isDirective() {
-		for indentLevel := 0; indentLevel < formatter.Indent; indentLevel++ {
-			formatter.writeString(indentation)
-		}
+	indent := formatter.Indent + formatter.layoutLevel()
+
+	if formatter.isGotoLabel(formatter.TokenIndex + 1) {
+		indent = formatter.labelIndent(indent)
+	}
+
+	if formatter.nextToken().isDirective() {
+		return
+	}
+
+	if column := formatter.layoutColumn(); column > 0 {
+		formatter.writeString(formatter.indentationTo(column))
+		return
 	}
 
+	formatter.writeString(formatter.indentationTo(max(indent, 0) * formatter.Options.IndentWidth))
+
 }
 
 func (f *Formatter) writeDefaultLines() {
 
 	switch f.Node().Type {
-	case NodeTypeTopLevel:
+	case NodeTypeTopLevel, NodeTypeLinkageSpecification:
 		f.twoLinesOrEof()
 	case NodeTypeDirective,
 		NodeTypeFuncOrMacroCall,
@@ -402,9 +592,10 @@ func (f *Formatter) writeDefaultLines() {
 		NodeTypeInitializerList,
 		NodeTypeStructOrUnion,
 		NodeTypeEnum,
-		NodeTypeForLoopParenthesis:
+		NodeTypeForLoopParenthesis,
+		NodeTypeImplicitBlock:
 		f.writeNewLines(1)
-	case NodeTypeBlock:
+	case NodeTypeBlock, NodeTypeSwitch:
 		f.oneOrTwoLines()
 	default:
 		panic("unreachable")
@@ -415,15 +606,6 @@ func (formatter *Formatter) IsParenthesis() bool {
 	return formatter.OpenParenthesis > 0
 }
 
-func (f *Formatter) isPointerOperator() bool {
-	return f.token().canBePointerOperator() &&
-		!f.previousToken().isConstant() &&
-		!f.previousToken().isRightParenthesis() &&
-		!f.previousToken().isRightBracket() &&
-		!f.nextToken().isConstant() &&
-		(!f.isRightSideOfAssignment() || f.previousToken().isRightParenthesis() || f.previousToken().isRightBracket() || f.previousToken().isComma() || f.previousToken().isRightBracket() || f.previousToken().isAssignment() || f.Node().isFuncOrMacroDef() || f.Node().isStructOrUnion() || f.previousToken().isLeftBracesBracketsOrParenthesis())
-}
-
 func (f *Formatter) isUnaryPlusMinus() bool {
 	return f.token().isPlusOrMinus() &&
 		!f.previousToken().canBeLeftOperand() &&
@@ -442,14 +624,47 @@ func (f *Formatter) isPrefixIncrDecr() bool {
 }
 
 func (f *Formatter) hasTrailingComment() bool {
-	return f.nextToken().isSingleLineComment() &&
+	next := f.nextToken()
+
+	return (next.isSingleLineComment() ||
+		(next.isOneLineComment() && (next.hasNewLines() || f.tokenAt(f.TokenIndex+2).isAbsent()))) &&
 		f.token().Whitespace.NewLines == 0
 }
 
+// Whether the current token ends a statement outside of structs and directives. A
+// comment that trails the statement ends it instead of its semicolon, so that the
+// statement is broken if the comment goes past the column limit.
+func (f *Formatter) endsStatement() bool {
+	index := f.TokenIndex
+
+	if f.token().isComment() && f.previousToken().Whitespace.NewLines == 0 {
+		index--
+	}
+
+	return f.tokenAt(index).isSemicolon() && !f.hasTrailingComment() &&
+		!f.Node().isStructOrUnion() && !f.Node().isDirective()
+}
+
+// Whether the token at index is a /* */ comment followed by code on its line, as in
+// foo(/*verbose=*/true), which stays in place like any other token
+func (f *Formatter) isInlineComment(index int) bool {
+	return f.tokenAt(index).isOneLineComment() && !f.tokenAt(index).hasNewLines() &&
+		!f.tokenAt(index+1).isAbsent()
+}
+
 func (f *Formatter) formatMultilineComment() {
 	text := strings.TrimSpace(f.token().Content[2 : len(f.token().Content)-2])
 
 	lines := strings.Split(text, "\n")
+
+	if f.reflowsComments() {
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace(line)
+		}
+
+		lines = reflowLines(lines, f.Options.ColumnLimit-f.OutputColumn-len("   "))
+	}
+
 	f.writeString("/*")
 
 	for _, line := range lines {
@@ -467,24 +682,50 @@ func (f *Formatter) formatMultilineComment() {
 }
 
 func (f *Formatter) formatSingleLineComment() {
+	// Reflowed comments keep the indentation of their code samples
+	if f.reflowsComments() {
+		marker := lineCommentMarker.FindString(f.token().Content)
+		text := strings.TrimPrefix(f.token().Content[len(marker):], " ")
+		f.writeString(strings.TrimRight(marker+" "+text, " \t\r"))
+		return
+	}
+
 	text := strings.TrimSpace(f.token().Content[2:])
 	f.writeString("// ")
 	f.writeString(text)
 }
 
+func (f *Formatter) splitsStrings() bool {
+	return f.Options.SplitStrings && f.Options.ColumnLimit > 0 && !f.Node().isDirective()
+}
+
+func (f *Formatter) reflowsComments() bool {
+	return f.Options.ReflowComments && f.Options.ColumnLimit > 0
+}
+
 func (f *Formatter) formatToken() {
 
-	if f.token().isMultilineComment() {
+	if f.token().isMultilineComment() && (f.Options.VerbatimBlockComments || isDecoratedComment(f.token().Content)) {
+		f.formatDecoratedComment()
+	} else if f.token().isMultilineComment() && !f.token().isOneLineComment() {
 		f.formatMultilineComment()
 	} else if f.token().isSingleLineComment() {
 		f.formatSingleLineComment()
+	} else if f.token().isDirective() {
+		f.formatDirective()
 	} else {
 		f.writeString(f.token().Content)
 	}
 }
 
 func (f *Formatter) startsFuncOrMacroDef() bool {
-	return f.startsFunctionArguments() && f.Node().isTopLevel()
+	return f.startsFunctionArguments() && f.Node().isDeclarationScope()
+}
+
+func (f *Formatter) isLinkageSpecificationStart() bool {
+	return f.token().isLeftBrace() &&
+		f.previousToken().isString() &&
+		f.tokenAt(f.TokenIndex-2).isExtern()
 }
 
 func (f *Formatter) startsFunctionArguments() bool {
@@ -502,26 +743,12 @@ func (f *Formatter) isBlockStart() bool {
 	return f.Node().isBlock() && f.isNodeStart()
 }
 
-func (f *Formatter) isInitializerListStart() bool {
-	return f.Node().isInitializerList() && f.isNodeStart()
-}
-
-func (f *Formatter) isFuncOrMacroStart() bool {
-	return f.Node().isFuncOrMacro() && f.isNodeStart()
-}
-
 func (f *Formatter) isFunctionName() bool {
 	return f.token().Type == TokenTypeIdentifier &&
 		f.nextToken().isLeftParenthesis() &&
 		(!f.Node().isDirective() || !f.token().Whitespace.HasSpace)
 }
 
-func (f *Formatter) isFunctionStart() bool {
-	return f.previousToken().Type == TokenTypeIdentifier &&
-		f.token().isLeftParenthesis() &&
-		(!f.Node().isDirective() || !f.token().Whitespace.HasSpace)
-}
-
 func (f *Formatter) neverSpace() bool {
 
 	return f.nextToken().isSemicolon() ||
@@ -536,7 +763,9 @@ func (f *Formatter) neverSpace() bool {
 		f.nextToken().isDoubleColon() ||
 
 		f.isPointerOperator() ||
-		(f.nextToken().isColon() && !f.isRightSideOfAssignment()) ||
+		f.beforeLeftAlignedPointer() ||
+		(f.nextToken().isColon() && !f.isRightSideOfAssignment() &&
+			!(f.Options.AlignStructMembers && f.isBitfieldColon(f.TokenIndex+1))) ||
 		f.isUnaryPlusMinus() ||
 		f.isFunctionName() ||
 		f.hasPostfixIncrDecr() ||
@@ -554,59 +783,44 @@ func (f *Formatter) neverSpace() bool {
 		f.token().isTokenPastingOp() ||
 		f.nextToken().isTokenPastingOp() ||
 		(f.Node().DirectiveType == DirectiveTypeInclude &&
-			((f.nextToken().isGreaterThanSign()) || f.token().isLessThanSign() || f.previousToken().isLessThanSign()))
-}
-
-func (f *Formatter) wrappingStrategyComma() bool {
-	return f.Node().isFuncOrMacro()
-}
-
-func (f *Formatter) wrappingStrategyLineBreakAfterComma() bool {
-	return f.Node().isInitializerList()
+			((f.nextToken().isGreaterThanSign()) || f.token().isLessThanSign() || f.previousToken().isLessThanSign())) ||
+		(f.isInsideIncludeBrackets() && !f.token().Whitespace.HasSpace) ||
+		// As in foo(/*verbose=*/true)
+		(f.isInlineComment(f.TokenIndex) && !f.token().Whitespace.HasSpace)
 }
 
 func (f *Formatter) alwaysOneLine() bool {
 
 	return f.nextToken().isAbsent() ||
-		(f.token().isComment() && (f.previousToken().hasNewLines() || f.previousToken().isAbsent())) ||
-		(f.afterInclude() && f.nextToken().isIncludeDirective()) ||
+		(f.token().isComment() && !f.isInlineComment(f.TokenIndex) &&
+			(f.previousToken().hasNewLines() || f.previousToken().isAbsent())) ||
+		(f.afterInclude() && f.nextToken().isIncludeDirective() && !f.endsIncludeGroup()) ||
 		(f.afterPragma() && f.nextToken().isPragmaDirective()) ||
 		(f.afterPragma() && f.nextToken().isPragmaDirective()) ||
-		(f.Node().isStructOrUnion() && f.token().isSemicolon()) ||
-		((f.Node().isEnum()) && f.token().isComma()) ||
-		((f.Node().isStructOrUnion() || f.Node().isBlock() || f.Node().isEnum()) &&
+		(f.Node().isStructOrUnion() && f.token().isSemicolon() && !f.hasTrailingComment()) ||
+		(f.Node().isEnum() && f.token().isComma() && !f.hasTrailingComment()) ||
+		(f.Node().isEnum() && f.token().hasNewLines() && f.nextToken().isComment()) ||
+		((f.Node().isStructOrUnion() || f.Node().isBlock() || f.Node().isEnum() || f.Node().isLinkageSpecification()) &&
 			(f.isNodeStart() || f.nextToken().isRightBrace())) ||
-		(f.Wrapping && f.isWrappingNode() && f.wrappingStrategyComma() && f.token().isComma()) ||
-		(f.Wrapping && f.isWrappingNode() && f.isInitializerListStart()) ||
-		(f.Wrapping && f.isWrappingNode() && f.isFuncOrMacroStart()) ||
-		(f.Wrapping && f.isWrappingNode() && f.beforeEndOfFuncOrMacro()) ||
 		f.isBlockStart() ||
-		(f.Wrapping &&
-			f.isWrappingNode() &&
-			f.Node().isInitializerList() &&
-			f.nextToken().isRightBrace()) ||
-		(f.Wrapping && f.isWrappingNode() &&
-			f.wrappingStrategyLineBreakAfterComma() &&
-			f.token().isComma() &&
-			f.token().hasNewLines())
-}
-
-func (f *Formatter) indentedWrapping() bool {
-	return (f.Wrapping && f.isWrappingNode() &&
-		(f.Node().isBlock() || f.Node().isTopLevel() || f.Node().isFuncOrMacro()) &&
-		f.token().hasNewLines())
+		(f.isImplicitBlockStart() && f.token().hasNewLines()) ||
+		(f.afterCaseLabel() && !f.hasTrailingComment()) ||
+		(f.isGotoLabelEnd() && !f.hasTrailingComment()) ||
+		f.continuesAlignedRun()
 }
 
 func (f *Formatter) alwaysDefaultLines() bool {
 	return (f.nextToken().isDirective() && !f.previousToken().isAbsent()) ||
 		f.isEndOfDirective() ||
 		(f.token().isComment() &&
+			!f.isInlineComment(f.TokenIndex) &&
 			!f.previousToken().hasNewLines() &&
 			!f.previousToken().isAbsent()) ||
-		f.nextToken().isMultilineComment() ||
+		(f.nextToken().isMultilineComment() && !f.isInlineComment(f.TokenIndex+1) && !f.hasTrailingComment()) ||
 		(f.token().isSemicolon() && !f.Node().isForLoopParenthesis() && !f.hasTrailingComment()) ||
 		(f.Node().isDirective() && f.token().hasEscapedLines()) ||
-		(f.afterEndOfBlock() && !(f.LastPop.BlockType == BlockTypeDoWhile))
+		(f.afterEndOfBlock() && !(f.LastPop.BlockType == BlockTypeDoWhile) &&
+			!(f.LastPop.isImplicitBlock() && f.hasTrailingComment()))
 }
 
 func (f *Formatter) Node() *Node {
@@ -658,10 +872,7 @@ func (f *Formatter) popNode() {
 
 	f.LastPop = *f.Node()
 	f.LastPop.LastToken = f.TokenIndex
-	if f.WrappingNode == f.Node().Id {
-		f.WrappingNode = 0
-	}
-	if f.Node().isDirective() {
+	if f.Node().isDirective() || f.Node().isImplicitBlock() || f.Node().BraceStyle == BraceStyleGnu {
 		f.Indent = f.Node().InitialIndent
 	}
 
@@ -684,11 +895,8 @@ func (f *Formatter) isNodeStart() bool {
 }
 
 func (f *Formatter) afterEndOfBlock() bool {
-	return f.LastPop.isBlock() && f.LastPop.LastToken == f.TokenIndex
-}
-
-func (f *Formatter) isWrappingNode() bool {
-	return f.WrappingNode == f.Node().Id
+	return (f.LastPop.isBlock() || f.LastPop.isLinkageSpecification() || f.LastPop.isImplicitBlock()) &&
+		f.LastPop.LastToken == f.TokenIndex
 }
 
 func (f *Formatter) isTopLevelInNode() bool {
@@ -704,12 +912,6 @@ func (f *Formatter) afterPragma() bool {
 	return f.LastPop.isPragmaDirective() && f.LastPop.LastToken == f.TokenIndex
 }
 
-func (f *Formatter) beforeEndOfFuncOrMacro() bool {
-	return f.Node().isFuncOrMacro() &&
-		f.nextToken().isRightParenthesis() &&
-		f.OpenParenthesis == f.Node().InitialParenthesis
-}
-
 func (f *Formatter) isRightSideOfAssignment() bool {
 	for _, node := range f.Nodes {
 		if node.RightSideOfAssignment {
@@ -719,29 +921,3 @@ func (f *Formatter) isRightSideOfAssignment() bool {
 
 	return false
 }
-
-func (f *Formatter) functionIsEntireRightSide() bool {
-	if !f.tokenAt(f.TokenIndex - 2).isAssignment() {
-		return false
-	}
-
-	i := f.TokenIndex + 1
-
-	openParenthesis := 1
-
-	for token := f.tokenAt(i); !token.isAbsent(); token = f.tokenAt(i) {
-		if token.isLeftParenthesis() {
-			openParenthesis++
-		}
-		if token.isRightParenthesis() {
-			openParenthesis--
-		}
-
-		if openParenthesis == 0 {
-			break
-		}
-		i++
-	}
-
-	return f.tokenAt(i + 1).isSemicolon()
-}
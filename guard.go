@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type HeaderGuard int
+
+const (
+	HeaderGuardKeep HeaderGuard = iota
+	HeaderGuardIfndef
+	HeaderGuardPragmaOnce
+)
+
+type HeaderGuardName struct {
+	Name        string
+	HeaderGuard HeaderGuard
+}
+
+var headerGuardNames = [...]HeaderGuardName{
+	{"keep", HeaderGuardKeep},
+	{"ifndef", HeaderGuardIfndef},
+	{"pragma-once", HeaderGuardPragmaOnce},
+}
+
+func parseHeaderGuard(name string) (HeaderGuard, error) {
+	for _, n := range headerGuardNames {
+		if n.Name == name {
+			return n.HeaderGuard, nil
+		}
+	}
+
+	return HeaderGuardKeep, fmt.Errorf("invalid header guard: %s", name)
+}
+
+func (h HeaderGuard) String() string {
+	for _, n := range headerGuardNames {
+		if n.HeaderGuard == h {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected header guard %d", h))
+}
+
+var HEADER_EXTENSIONS = [...]string{".h", ".hh", ".hpp", ".hxx"}
+
+var headerGuardPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// Runs of characters a guard name is not made of, replaced by _
+var headerGuardSeparator = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// An IncludeGuard is either #pragma once or an #ifndef/#define pair whose #endif ends
+// the file, comments aside
+type IncludeGuard struct {
+	Found        bool
+	IsPragmaOnce bool
+	Name         string
+	Line         int
+	// Matches the name as a whole word, as in a comment after the #endif
+	NamePattern *regexp.Regexp
+}
+
+func isHeaderPath(path string) bool {
+	return slices.Contains(HEADER_EXTENSIONS[:], strings.ToLower(filepath.Ext(path)))
+}
+
+// Expands the placeholders of a guard name template for the header at path. {PATH}
+// is the path relative to the working directory, {DIR} the name of the directory of
+// the header, {FILE} its name without extension and {EXT} its extension. The result
+// is upper case, with runs of other characters than letters and digits replaced by _.
+func headerGuardMacro(template string, path string) (string, error) {
+	relative := path
+
+	if filepath.IsAbs(path) {
+		if wd, err := os.Getwd(); err == nil {
+			if r, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(r, "..") {
+				relative = r
+			}
+		}
+	}
+
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	values := map[string]string{
+		"{PATH}": strings.TrimLeft(filepath.ToSlash(filepath.Clean(relative)), "./"),
+		"{DIR}":  filepath.Base(filepath.Dir(relative)),
+		"{FILE}": strings.TrimSuffix(base, ext),
+		"{EXT}":  strings.TrimPrefix(ext, "."),
+	}
+
+	if values["{DIR}"] == "." {
+		values["{DIR}"] = ""
+	}
+
+	var err error
+
+	name := headerGuardPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, found := values[placeholder]
+
+		if !found {
+			err = fmt.Errorf("unknown placeholder %s", placeholder)
+		}
+
+		return value
+	})
+
+	name = strings.Trim(headerGuardSeparator.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+
+	if err == nil && name == "" {
+		err = fmt.Errorf("empty header guard for %s", path)
+	}
+
+	return name, err
+}
+
+// Lexes text as a whole, with backslash-newlines joining lines everywhere
+func lexTokens(text string) []Token {
+	f := Formatter{Input: &text, Tokens: new([]Token), InputLine: new(int), InputColumn: new(int)}
+	f.pushNode(NodeTypeDirective)
+	_ = f.skipSpaceAndCountNewLines()
+	result := []Token{}
+
+	for i := 0; ; i++ {
+		token := f.tokenAt(i)
+
+		if token.isAbsent() || token.isInvalid() {
+			return result
+		}
+
+		result = append(result, token)
+	}
+}
+
+// Returns the end of the line starting at index, past its trailing comment, if it
+// holds the given number of tokens before the comment
+func lineOfLength(tokens []Token, index int, length int) (int, bool) {
+	end := index + length
+
+	if end > len(tokens) {
+		return 0, false
+	}
+
+	for i := index; i < end-1; i++ {
+		if tokens[i].hasUnescapedLines() || tokens[i].isComment() {
+			return 0, false
+		}
+	}
+
+	if end < len(tokens) && !tokens[end-1].hasUnescapedLines() && tokens[end].isComment() {
+		end++
+	}
+
+	return end, end == len(tokens) || tokens[end-1].hasUnescapedLines()
+}
+
+func onlyComments(tokens []Token) bool {
+	for _, token := range tokens {
+		if !token.isComment() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func findIncludeGuard(tokens []Token) IncludeGuard {
+	i := 0
+
+	for i < len(tokens) && tokens[i].isComment() {
+		i++
+	}
+
+	if i == len(tokens) {
+		return IncludeGuard{}
+	}
+
+	if _, found := lineOfLength(tokens, i, 2); found &&
+		tokens[i].isPragmaDirective() && tokens[i+1].Content == "once" {
+		return IncludeGuard{Found: true, IsPragmaOnce: true, Line: tokens[i].Line}
+	}
+
+	ifndefEnd, found := lineOfLength(tokens, i, 2)
+
+	if !found || tokens[i].DirectiveType != DirectiveTypeIfndef || !tokens[i+1].isIdentifier() {
+		return IncludeGuard{}
+	}
+
+	name := tokens[i+1].Content
+	defineEnd, found := lineOfLength(tokens, ifndefEnd, 2)
+
+	if !found || !tokens[ifndefEnd].isDefine() || tokens[ifndefEnd+1].Content != name {
+		return IncludeGuard{}
+	}
+
+	depth := 0
+
+	for j := defineEnd; j < len(tokens); j++ {
+		switch {
+		case !tokens[j].isDirective():
+		case tokens[j].DirectiveType == DirectiveTypeIf || tokens[j].DirectiveType == DirectiveTypeIfdef ||
+			tokens[j].DirectiveType == DirectiveTypeIfndef:
+			depth++
+		case tokens[j].DirectiveType == DirectiveTypeEndif && depth > 0:
+			depth--
+		case tokens[j].DirectiveType == DirectiveTypeEndif:
+			endifEnd, found := lineOfLength(tokens, j, 1)
+
+			if found && onlyComments(tokens[endifEnd:]) {
+				return IncludeGuard{
+					Found:       true,
+					Name:        name,
+					Line:        tokens[i].Line,
+					NamePattern: regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`),
+				}
+			}
+
+			return IncludeGuard{}
+		}
+	}
+
+	return IncludeGuard{}
+}
+
+// Reports a header whose guard is missing or does not follow the header-guard options
+func checkHeaderGuard(input string, path string, options Options) error {
+	if !isHeaderPath(path) {
+		return nil
+	}
+
+	guard := findIncludeGuard(lexTokens(strings.TrimPrefix(input, BYTE_ORDER_MARK)))
+	expected, err := headerGuardMacro(options.HeaderGuardTemplate, path)
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !guard.Found && options.HeaderGuard == HeaderGuardPragmaOnce:
+		return fmt.Errorf("1: missing #pragma once")
+	case !guard.Found:
+		return fmt.Errorf("1: missing include guard")
+	case guard.IsPragmaOnce && options.HeaderGuard == HeaderGuardIfndef:
+		return fmt.Errorf("%d: #pragma once instead of include guard %s", guard.Line+1, expected)
+	case guard.IsPragmaOnce:
+		return nil
+	case options.HeaderGuard == HeaderGuardPragmaOnce:
+		return fmt.Errorf("%d: include guard %s instead of #pragma once", guard.Line+1, guard.Name)
+	case guard.Name != expected:
+		return fmt.Errorf("%d: include guard %s should be %s", guard.Line+1, guard.Name, expected)
+	default:
+		return nil
+	}
+}
+
+func (f *Formatter) isFirstCode() bool {
+	for i := 0; i < f.TokenIndex; i++ {
+		if !f.tokenAt(i).isComment() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether the #endif at index ends the file, comments aside. The ones after it are
+// lexed ahead.
+func (f *Formatter) isLastEndif(index int) (int, bool) {
+	if f.tokenAt(index).DirectiveType != DirectiveTypeEndif || !f.tokenAt(index).isDirective() {
+		return 0, false
+	}
+
+	end, _, found := f.directiveLine(index)
+
+	if !found {
+		return 0, false
+	}
+
+	for i := end; !f.tokenAt(i).isAbsent(); i++ {
+		if !f.tokenAt(i).isComment() {
+			return 0, false
+		}
+	}
+
+	return end, true
+}
+
+// Renames or converts the include guard of a header as the formatter reaches its
+// lines. Each change leaves tokens it would not change again, since the formatter can
+// go back to an earlier token when it wraps a line.
+func (f *Formatter) updateHeaderGuard() {
+	if !f.IncludeGuard.Found || f.Options.HeaderGuard == HeaderGuardKeep {
+		return
+	}
+
+	name := f.HeaderGuardMacro
+	isGuardStart := (f.token().isPragmaDirective() || f.token().DirectiveType == DirectiveTypeIfndef) &&
+		f.isFirstCode()
+
+	switch {
+	case f.Options.HeaderGuard == HeaderGuardIfndef && f.IncludeGuard.IsPragmaOnce:
+		if isGuardStart && f.token().isPragmaDirective() {
+			end, _, _ := f.directiveLine(f.TokenIndex)
+			tokens := *f.Tokens
+			guard := lexTokens(fmt.Sprintf("#ifndef %s\n#define %s", name, name))
+			guard[len(guard)-1].Whitespace = tokens[end-1].Whitespace
+			*f.Tokens = slices.Replace(tokens, f.TokenIndex, end, guard...)
+		}
+
+		if f.nextToken().isAbsent() && !(f.token().DirectiveType == DirectiveTypeEndif && f.token().Inserted) {
+			tokens := *f.Tokens
+			endif := lexTokens("#endif")
+			endif[0].Inserted = true
+			tokens[f.TokenIndex].Whitespace = Whitespace{NewLines: 1, HasUnescapedLines: true}
+			*f.Tokens = slices.Insert(tokens, f.TokenIndex+1, endif...)
+		}
+	case f.Options.HeaderGuard == HeaderGuardIfndef:
+		if isGuardStart && f.token().DirectiveType == DirectiveTypeIfndef {
+			ifndefEnd, _, _ := f.directiveLine(f.TokenIndex)
+			f.tokenAt(ifndefEnd + 1)
+			tokens := *f.Tokens
+			tokens[f.TokenIndex+1].Content = name
+			tokens[ifndefEnd+1].Content = name
+		}
+
+		// The name of the guard is often repeated in a comment after #endif
+		if end, found := f.isLastEndif(f.TokenIndex); found && (*f.Tokens)[end-1].isComment() {
+			comment := &(*f.Tokens)[end-1]
+			comment.Content = f.IncludeGuard.NamePattern.ReplaceAllLiteralString(comment.Content, name)
+		}
+	case f.Options.HeaderGuard == HeaderGuardPragmaOnce && !f.IncludeGuard.IsPragmaOnce:
+		if isGuardStart && f.token().DirectiveType == DirectiveTypeIfndef {
+			ifndefEnd, _, _ := f.directiveLine(f.TokenIndex)
+			defineEnd, _, _ := f.directiveLine(ifndefEnd)
+			tokens := *f.Tokens
+			pragma := lexTokens("#pragma once")
+			pragma[len(pragma)-1].Whitespace = tokens[defineEnd-1].Whitespace
+			*f.Tokens = slices.Replace(tokens, f.TokenIndex, defineEnd, pragma...)
+		}
+
+		// The #endif is removed before the formatter reaches it, once the #ifndef it
+		// closed is gone
+		if end, found := f.isLastEndif(f.TokenIndex + 1); found && len(f.Conditionals) == 0 {
+			*f.Tokens = slices.Delete(*f.Tokens, f.TokenIndex+1, end)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"cmp"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The tokens of a line, from Start to End excluded
+type TokenRange struct {
+	Start int
+	End   int
+}
+
+// An IncludeLine is the range of tokens of an #include line, from the directive to the
+// end of the line, which may be a trailing comment
+type IncludeLine struct {
+	TokenRange
+	Header     string
+	HasComment bool
+}
+
+func parseIncludeCategories(value string) ([]*regexp.Regexp, error) {
+	result := []*regexp.Regexp{}
+
+	for _, pattern := range strings.Fields(value) {
+		category, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, category)
+	}
+
+	return result, nil
+}
+
+// Returns the end of the line of the directive at index, past its last token, and the
+// start of its trailing comment, which is the end if there is none. Lines continued
+// with a backslash, or with a comment before their end, are rejected.
+func (f *Formatter) directiveLine(index int) (int, int, bool) {
+	for i := index; ; i++ {
+		token := f.tokenAt(i)
+
+		if token.isAbsent() || token.hasEscapedLines() {
+			return 0, 0, false
+		}
+
+		isLineEnd := token.hasUnescapedLines() || f.tokenAt(i+1).isAbsent()
+
+		if token.isComment() && i > index {
+			return i + 1, i, isLineEnd
+		}
+
+		if isLineEnd {
+			return i + 1, i + 1, true
+		}
+	}
+}
+
+// Returns the line of the #include at index, or false if it is not one whose header
+// is written literally, as in <stdio.h> or "foo.h"
+func (f *Formatter) includeLine(index int) (IncludeLine, bool) {
+	if !f.tokenAt(index).isIncludeDirective() {
+		return IncludeLine{}, false
+	}
+
+	end, comment, found := f.directiveLine(index)
+
+	if !found {
+		return IncludeLine{}, false
+	}
+
+	var header strings.Builder
+
+	for i := index + 1; i < comment; i++ {
+		header.WriteString(f.tokenAt(i).Content)
+	}
+
+	name := header.String()
+	isSystem := strings.HasPrefix(name, "<") && strings.HasSuffix(name, ">")
+	isLocal := len(name) > 1 && strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`)
+
+	if !isSystem && !isLocal {
+		return IncludeLine{}, false
+	}
+
+	return IncludeLine{TokenRange{index, end}, name, comment < end}, true
+}
+
+// Whether the header is the one declaring what the file being formatted defines, as
+// "foo.h" or "dir/foo.h" for foo.c
+func (f *Formatter) isMainHeader(header string) bool {
+	if f.Path == "" || !strings.HasPrefix(header, `"`) {
+		return false
+	}
+
+	base := filepath.Base(f.Path)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	name := filepath.Base(strings.Trim(header, `"`))
+
+	return name != base && strings.TrimSuffix(name, filepath.Ext(name)) == stem
+}
+
+// Returns the group of an #include. The main header comes first, then the headers of
+// each category in order, then the other system headers and the other local headers.
+func (f *Formatter) includePriority(header string) int {
+	if f.isMainHeader(header) {
+		return 0
+	}
+
+	for i, category := range f.IncludeCategories {
+		if category.MatchString(header) {
+			return i + 1
+		}
+	}
+
+	if strings.HasPrefix(header, "<") {
+		return len(f.IncludeCategories) + 1
+	}
+
+	return len(f.IncludeCategories) + 2
+}
+
+// Whether the current token is part of a header name written between < and >, where
+// spaces are kept as they are
+func (f *Formatter) isInsideIncludeBrackets() bool {
+	if f.Node().DirectiveType != DirectiveTypeInclude || !f.tokenAt(f.Node().FirstToken+1).isLessThanSign() {
+		return false
+	}
+
+	for i := f.Node().FirstToken + 1; i <= f.TokenIndex; i++ {
+		if f.tokenAt(i).isGreaterThanSign() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether the current token starts a block of #include lines that can be sorted. An
+// #include whose header is a macro separates blocks.
+func (f *Formatter) isIncludeBlockStart() bool {
+	if !f.token().isIncludeDirective() {
+		return false
+	}
+
+	if !f.LastPop.isIncludeDirective() || f.LastPop.LastToken != f.TokenIndex-1 {
+		return true
+	}
+
+	_, isPreviousSorted := f.includeLine(f.LastPop.FirstToken)
+
+	return !isPreviousSorted
+}
+
+// Whether the current token ends an #include that is followed by one of another group
+func (f *Formatter) endsIncludeGroup() bool {
+	if !f.Options.SortIncludes || !f.afterInclude() {
+		return false
+	}
+
+	current, isCurrentSorted := f.includeLine(f.LastPop.FirstToken)
+	next, isNextSorted := f.includeLine(f.TokenIndex + 1)
+
+	return isCurrentSorted && isNextSorted &&
+		f.includePriority(current.Header) != f.includePriority(next.Header)
+}
+
+// Sorts the #include lines that follow each other from the current token, removing
+// duplicates. Of duplicates, the one with a trailing comment is kept, and those with
+// different comments are all kept. A line that is not an #include, such as a comment
+// or a conditional directive, ends the block.
+func (f *Formatter) sortIncludes() {
+	if !f.Options.SortIncludes || !f.isIncludeBlockStart() {
+		return
+	}
+
+	lines := []IncludeLine{}
+
+	for index := f.TokenIndex; ; {
+		line, found := f.includeLine(index)
+
+		if !found {
+			break
+		}
+
+		lines = append(lines, line)
+		index = line.End
+	}
+
+	if len(lines) < 2 {
+		return
+	}
+
+	sorted := slices.Clone(lines)
+
+	slices.SortStableFunc(sorted, func(a IncludeLine, b IncludeLine) int {
+		if c := cmp.Compare(f.includePriority(a.Header), f.includePriority(b.Header)); c != 0 {
+			return c
+		}
+
+		if c := cmp.Compare(a.Header[1:len(a.Header)-1], b.Header[1:len(b.Header)-1]); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.Header, b.Header)
+	})
+
+	kept := []IncludeLine{}
+
+	for _, line := range sorted {
+		n := len(kept)
+
+		switch {
+		case n == 0 || kept[n-1].Header != line.Header:
+			kept = append(kept, line)
+		case !line.HasComment:
+		case !kept[n-1].HasComment:
+			kept[n-1] = line
+		case !slices.ContainsFunc(kept, func(other IncludeLine) bool {
+			return other.Header == line.Header && f.includeComment(other) == f.includeComment(line)
+		}):
+			kept = append(kept, line)
+		}
+	}
+
+	ranges := []TokenRange{}
+
+	for _, line := range kept {
+		ranges = append(ranges, line.TokenRange)
+	}
+
+	f.replaceLines(lines[0].Start, lines[len(lines)-1].End, ranges)
+}
+
+// Returns the trailing comment of an #include line, or an empty string if it has none
+func (f *Formatter) includeComment(line IncludeLine) string {
+	if !line.HasComment {
+		return ""
+	}
+
+	return f.tokenAt(line.End - 1).Content
+}
+
+// Replaces the lines of tokens from start to end with the given lines, taken from the
+// same tokens. Line breaks stay where they are, so the last line keeps the blank lines
+// after it.
+func (f *Formatter) replaceLines(start int, end int, lines []TokenRange) {
+	tokens := *f.Tokens
+	lineEnds := []Whitespace{}
+
+	for i := start; i < end; i++ {
+		if tokens[i].hasUnescapedLines() || i == end-1 {
+			lineEnds = append(lineEnds, tokens[i].Whitespace)
+		}
+	}
+
+	lineEnds = append(lineEnds[:len(lines)-1], lineEnds[len(lineEnds)-1])
+	block := []Token{}
+
+	for i, line := range lines {
+		block = append(block, tokens[line.Start:line.End]...)
+		block[len(block)-1].Whitespace = lineEnds[i]
+	}
+
+	*f.Tokens = slices.Replace(tokens, start, end, block...)
+}
@@ -0,0 +1,1131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Costs of the layouts of a statement. The formatter writes the cheapest one.
+const (
+	PENALTY_LINE_BREAK       int = 10
+	PENALTY_EXCESS_CHARACTER int = 1000
+	PENALTY_NESTING          int = 5
+)
+
+// Number of layouts of a statement, whole or in part, the solver tries at most
+const LAYOUT_SEARCH_LIMIT int = 1024
+
+// How a broken layout group uses its break points
+type BreakMode int
+
+const (
+	// A line break at each break point
+	BreakModeEach BreakMode = iota
+	// A line break only where the part that follows would not fit
+	BreakModeFill
+)
+
+// How the arguments of a call, or the parameters of a definition, are laid out when
+// they do not fit on one line
+type ArgumentWrapping int
+
+const (
+	// One per line, indented, with the ) on a line of its own
+	ArgumentWrappingOnePerLine ArgumentWrapping = iota
+	// As many per line as fit, indented, with the ) on a line of its own
+	ArgumentWrappingBinPack
+	// As many per line as fit, lined up after the (, or with the first one if that
+	// starts the next line
+	ArgumentWrappingAlign
+)
+
+type ArgumentWrappingName struct {
+	Name             string
+	ArgumentWrapping ArgumentWrapping
+}
+
+var argumentWrappingNames = [...]ArgumentWrappingName{
+	{"one-per-line", ArgumentWrappingOnePerLine},
+	{"bin-pack", ArgumentWrappingBinPack},
+	{"align", ArgumentWrappingAlign},
+}
+
+func parseArgumentWrapping(name string) (ArgumentWrapping, error) {
+	for _, n := range argumentWrappingNames {
+		if n.Name == name {
+			return n.ArgumentWrapping, nil
+		}
+	}
+
+	return ArgumentWrappingOnePerLine, fmt.Errorf("invalid argument wrapping: %s", name)
+}
+
+func (a ArgumentWrapping) String() string {
+	for _, n := range argumentWrappingNames {
+		if n.ArgumentWrapping == a {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected argument wrapping %d", a))
+}
+
+// A LayoutToken is a token as the formatter writes it when it does not break the
+// statement it is in
+type LayoutToken struct {
+	Token       Token
+	NodeType    NodeType
+	IsNodeStart bool
+	IsDirective bool
+	// Columns of its first character and of the end of its last line
+	Column    int
+	EndColumn int
+	// Columns between it and the token before it on the same line
+	Space int
+	// Indentation of the line it is on
+	LineIndent int
+	// Whether the formatter starts a line before it, as after a // comment
+	NewLine bool
+	// Whether it spans several lines, as a block comment
+	Multiline bool
+	// Whether it is a string literal the formatter splits when it does not fit
+	CanSplit bool
+}
+
+// A LayoutGroup is a part of a statement that is broken as a whole, such as the
+// arguments of a call or the operands of a chain of && operators. The lines that start
+// at its break points are indented one level past the group.
+type LayoutGroup struct {
+	Start int
+	End   int
+	// Tokens that can start a line when the group is broken
+	Breaks []int
+	// Break points that always start a line when the group is broken, as the line
+	// breaks of the input between the rows of an initializer list
+	Kept    []int
+	Penalty int
+	Mode    BreakMode
+	// Whether the last token goes back to the indentation of the group, as the ) of a
+	// call
+	HasClosing bool
+	// Whether the group holds a line break the formatter cannot undo
+	IsHard bool
+	// Whether the lines that start at its break points are lined up with its first
+	// token, as the arguments of a call, instead of indented
+	IsAligned bool
+	// Innermost bracket group around the group, as the call an argument is in, or the
+	// operator chain a parenthesized operand or a call is part of, or -1. The group is
+	// only broken if that one is.
+	Owner int
+}
+
+// A Layout tells which tokens of a statement start a line, from Start on, how many
+// levels past the statement each one is indented, and which ones start a broken group.
+// Lines lined up after a bracket start at the column in Columns instead, which is 0
+// for the others.
+type Layout struct {
+	Start   int
+	Breaks  []bool
+	Levels  []int
+	Columns []int
+	Groups  []bool
+}
+
+// Precedences of the binary operators a statement can be broken at, from the loosest
+const (
+	PrecedenceComma = iota
+	PrecedenceAssignment
+	PrecedenceConditional
+	PrecedenceLogicalOr
+	PrecedenceLogicalAnd
+	PrecedenceBitwiseOr
+	PrecedenceXor
+	PrecedenceEquality
+	PrecedenceRelational
+	PrecedenceShift
+	PrecedenceAdditive
+	PrecedenceMultiplicative
+)
+
+// Penalties of breaking a chain of operators, indexed by precedence. Breaking at a
+// looser operator is cheaper.
+var PRECEDENCE_PENALTIES = [...]int{
+	PrecedenceComma:          10,
+	PrecedenceAssignment:     5,
+	PrecedenceConditional:    15,
+	PrecedenceLogicalOr:      10,
+	PrecedenceLogicalAnd:     12,
+	PrecedenceBitwiseOr:      20,
+	PrecedenceXor:            22,
+	PrecedenceEquality:       30,
+	PrecedenceRelational:     30,
+	PrecedenceShift:          35,
+	PrecedenceAdditive:       40,
+	PrecedenceMultiplicative: 45,
+}
+
+const (
+	PENALTY_CALL        int = 20
+	PENALTY_FOR_LOOP    int = 50
+	PENALTY_INITIALIZER int = 10
+	PENALTY_STRINGS     int = 10
+)
+
+// Returns the precedence of the operator at index, or -1 if it is not a binary
+// operator. The & and * operators are left out, since they may be pointer operators.
+func binaryPrecedence(tokens []LayoutToken, index int, start int, hasQuestionMark bool) int {
+	token := tokens[index].Token
+
+	if token.Type != TokenTypePunctuation || index == start || tokens[index].IsDirective {
+		return -1
+	}
+
+	previous := tokens[index-1].Token
+
+	if !previous.canBeLeftOperand() && !previous.isRightBracket() && !previous.isIncrDecrOperator() {
+		return -1
+	}
+
+	switch token.PunctuationType {
+	case PunctuationTypeComma:
+		return PrecedenceComma
+	case PunctuationTypeQuestionMark:
+		return PrecedenceConditional
+	case PunctuationTypeColon:
+		if hasQuestionMark {
+			return PrecedenceConditional
+		}
+	case PunctuationTypeLogicalOr:
+		return PrecedenceLogicalOr
+	case PunctuationTpeLogicalAnd:
+		return PrecedenceLogicalAnd
+	case PunctuationTypeBitwiseOr:
+		return PrecedenceBitwiseOr
+	case PunctuationTypeXor:
+		return PrecedenceXor
+	case PunctuationTypeEquals, PunctuationTypeNotEquals:
+		return PrecedenceEquality
+	case PunctuationTypeGreater, PunctuationTypeLessThan, PunctuationTypeLessThanOrEquals, PunctuationTypeGreaterOrEqual:
+		return PrecedenceRelational
+	case PunctuationTypeLeftShift, PunctuationTypeRightShift:
+		return PrecedenceShift
+	case PunctuationTypePlus, PunctuationTypeMinus:
+		return PrecedenceAdditive
+	case PunctuationTypeDivide, PunctuationTypeRemainder:
+		return PrecedenceMultiplicative
+	default:
+		if token.isAssignment() {
+			return PrecedenceAssignment
+		}
+	}
+
+	return -1
+}
+
+// Brackets of directives, as the braces of a macro body, are left out of the
+// statement they are in
+func opensBracket(token LayoutToken) bool {
+	return !token.IsDirective &&
+		(token.Token.isLeftParenthesis() || token.Token.isLeftBracket() || token.Token.isLeftBrace())
+}
+
+func closesBracket(token LayoutToken) bool {
+	return !token.IsDirective &&
+		(token.Token.isRightParenthesis() || token.Token.isRightBracket() || token.Token.isRightBrace())
+}
+
+type layoutBuilder struct {
+	tokens     []LayoutToken
+	arguments  ArgumentWrapping
+	parameters ArgumentWrapping
+	groups     []LayoutGroup
+	owner      int
+	// Innermost operator chain around the expression being built, or -1
+	chain int
+	// Precedence of the operators of that chain
+	precedence int
+}
+
+// Returns the groups of the statements of tokens, outer groups first. Statements end at
+// semicolons, blocks, directives and comments on lines of their own.
+func buildLayoutGroups(tokens []LayoutToken, arguments ArgumentWrapping, parameters ArgumentWrapping) []LayoutGroup {
+	b := layoutBuilder{tokens: tokens, arguments: arguments, parameters: parameters, owner: -1, chain: -1}
+	open := 0
+	start := 0
+
+	for i, token := range tokens {
+		isSeparator := false
+
+		switch {
+		case (token.IsDirective || (token.Token.isComment() && token.NewLine)) && open == 0:
+			isSeparator = true
+		case token.IsDirective:
+		case token.Token.isLeftBrace() && open == 0 && token.NodeType != NodeTypeInitializerList:
+			isSeparator = true
+		case opensBracket(token):
+			open++
+		case closesBracket(token) && open == 0:
+			isSeparator = true
+		case closesBracket(token):
+			open--
+		case token.Token.isSemicolon() && open == 0:
+			isSeparator = true
+		}
+
+		if isSeparator {
+			b.expression(start, i-1, 0)
+			start = i + 1
+		}
+	}
+
+	b.expression(start, len(tokens)-1, 0)
+	b.markHardGroups()
+
+	return b.groups
+}
+
+// Returns the token that closes the bracket at index, or -1 if it is not closed by end
+func (b *layoutBuilder) matchingBracket(index int, end int) int {
+	open := 0
+
+	for i := index; i <= end; i++ {
+		if opensBracket(b.tokens[i]) {
+			open++
+		} else if closesBracket(b.tokens[i]) {
+			open--
+		}
+
+		if open == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns the separators between start and end that are not inside brackets
+func (b *layoutBuilder) separators(start int, end int, isSeparator func(index int) bool) []int {
+	result := []int{}
+
+	for i := start; i <= end; i++ {
+		if opensBracket(b.tokens[i]) {
+			if close := b.matchingBracket(i, end); close >= 0 {
+				i = close
+				continue
+			}
+		}
+
+		if isSeparator(i) {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+// Whether a line can start at the token at index. Lines never start with a comment
+// that trails the code before it, nor end with a /* */ comment inside the code, which
+// would then trail it.
+func (b *layoutBuilder) canBreakBefore(index int, end int) bool {
+	if index > end {
+		return false
+	}
+
+	token := b.tokens[index]
+	isTrailingComment := token.Token.isComment() && !token.NewLine &&
+		(!token.Token.isOneLineComment() || token.Token.hasNewLines())
+
+	return !token.IsDirective && !isTrailingComment &&
+		(index == 0 || !b.tokens[index-1].Token.isOneLineComment() || b.tokens[index-1].Token.hasNewLines())
+}
+
+// Adds a group if it has break points, and returns its index or -1
+func (b *layoutBuilder) addGroup(group LayoutGroup) int {
+	if len(group.Breaks) == 0 {
+		return -1
+	}
+
+	group.Owner = b.owner
+	b.groups = append(b.groups, group)
+
+	return len(b.groups) - 1
+}
+
+// Marks as hard the innermost group around each line break the formatter cannot undo,
+// such as the one after a // comment, and the bracket groups around it. The other
+// groups around it can still stay on one line.
+func (b *layoutBuilder) markHardGroups() {
+	for i, token := range b.tokens {
+		if !token.NewLine && !token.Multiline {
+			continue
+		}
+
+		innermost := -1
+
+		for j, group := range b.groups {
+			if group.Start < i && i <= group.End &&
+				(innermost < 0 || group.End-group.Start < b.groups[innermost].End-b.groups[innermost].Start) {
+				innermost = j
+			}
+		}
+
+		for j := innermost; j >= 0; j = b.groups[j].Owner {
+			b.groups[j].IsHard = true
+		}
+	}
+}
+
+// Adds the groups of the expression between start and end. Its loosest binary
+// operators make a group of their own, and the operands between them hold the
+// groups of the tighter ones.
+func (b *layoutBuilder) expression(start int, end int, depth int) {
+	if start > end {
+		return
+	}
+
+	hasQuestionMark := len(b.separators(start, end, func(index int) bool {
+		return b.tokens[index].Token.Type == TokenTypePunctuation &&
+			b.tokens[index].Token.PunctuationType == PunctuationTypeQuestionMark
+	})) > 0
+
+	loosest := -1
+
+	for _, i := range b.separators(start, end, func(index int) bool {
+		return binaryPrecedence(b.tokens, index, start, hasQuestionMark) >= 0
+	}) {
+		if precedence := binaryPrecedence(b.tokens, i, start, hasQuestionMark); loosest < 0 || precedence < loosest {
+			loosest = precedence
+		}
+	}
+
+	if loosest < 0 {
+		b.brackets(start, end, depth)
+		return
+	}
+
+	operators := b.separators(start, end, func(index int) bool {
+		return binaryPrecedence(b.tokens, index, start, hasQuestionMark) == loosest
+	})
+
+	group := LayoutGroup{
+		Start:   start,
+		End:     end,
+		Penalty: PRECEDENCE_PENALTIES[loosest] + depth*PENALTY_NESTING,
+		Mode:    BreakModeFill,
+	}
+
+	if loosest <= PrecedenceLogicalAnd {
+		group.Mode = BreakModeEach
+	}
+
+	for _, operator := range operators {
+		// Commas and assignments end their line, other operators start the next one. The
+		// brace of an initializer list stays after its =, unless the brace style moves it.
+		breakPoint := operator
+
+		if loosest <= PrecedenceAssignment {
+			breakPoint++
+		}
+
+		if b.canBreakBefore(breakPoint, end) &&
+			!(loosest == PrecedenceAssignment && b.tokens[breakPoint].NodeType == NodeTypeInitializerList) {
+			group.Breaks = append(group.Breaks, breakPoint)
+		}
+	}
+
+	chain, precedence := b.chain, b.precedence
+
+	if index := b.addGroup(group); index >= 0 {
+		b.chain, b.precedence = index, loosest
+	}
+
+	for _, operator := range operators {
+		b.expression(start, operator-1, depth)
+		start = operator + 1
+	}
+
+	b.expression(start, end, depth)
+	b.chain, b.precedence = chain, precedence
+}
+
+// Adds the groups of the brackets and adjacent string literals between start and end,
+// and of what the brackets hold
+func (b *layoutBuilder) brackets(start int, end int, depth int) {
+	for i := start; i <= end; i++ {
+		if b.tokens[i].Token.isString() {
+			i = b.strings(i, end, depth)
+			continue
+		}
+
+		if !opensBracket(b.tokens[i]) {
+			continue
+		}
+
+		close := b.matchingBracket(i, end)
+
+		if close < 0 {
+			return
+		}
+
+		b.bracket(i, close, depth)
+		i = close
+	}
+}
+
+// Adds the group of the adjacent string literals that start at index, and returns the
+// last one. Literals written on lines of their own in the input keep them.
+func (b *layoutBuilder) strings(index int, end int, depth int) int {
+	group := LayoutGroup{Start: index, End: index, Penalty: PENALTY_STRINGS + depth*PENALTY_NESTING, Mode: BreakModeFill}
+
+	for group.End+1 <= end && b.tokens[group.End+1].Token.isString() {
+		group.End++
+		group.Breaks = append(group.Breaks, group.End)
+
+		if b.tokens[group.End-1].Token.hasNewLines() {
+			group.Kept = append(group.Kept, group.End)
+			group.IsHard = true
+		}
+	}
+
+	b.addGroup(group)
+
+	return group.End
+}
+
+// Adds the group of the bracket between open and close, and the groups of its items
+func (b *layoutBuilder) bracket(open int, close int, depth int) {
+	token := b.tokens[open]
+
+	isItemSeparator := func(index int) bool {
+		return b.tokens[index].Token.isComma()
+	}
+
+	group := LayoutGroup{Start: open, End: close, Mode: BreakModeEach, HasClosing: true}
+	isTable := false
+	isCall := false
+
+	switch {
+	case token.Token.isLeftParenthesis() && token.IsNodeStart &&
+		(token.NodeType == NodeTypeFuncOrMacroCall || token.NodeType == NodeTypeFuncOrMacroDef):
+		group.Penalty = PENALTY_CALL
+		isCall = true
+		wrapping := b.arguments
+
+		if token.NodeType == NodeTypeFuncOrMacroDef {
+			wrapping = b.parameters
+		}
+
+		switch wrapping {
+		case ArgumentWrappingBinPack:
+			group.Mode = BreakModeFill
+		case ArgumentWrappingAlign:
+			group.Mode = BreakModeFill
+			group.HasClosing = false
+			group.IsAligned = true
+		}
+	case token.Token.isLeftParenthesis() && token.IsNodeStart && token.NodeType == NodeTypeForLoopParenthesis:
+		group.Penalty = PENALTY_FOR_LOOP
+		group.HasClosing = false
+
+		isItemSeparator = func(index int) bool {
+			return b.tokens[index].Token.isSemicolon()
+		}
+	case token.Token.isLeftBrace() && token.IsNodeStart && token.NodeType == NodeTypeInitializerList:
+		group.Penalty = PENALTY_INITIALIZER
+		group.Mode = BreakModeFill
+		isTable = true
+	case token.Token.isLeftBrace():
+		// Blocks keep their own lines
+		return
+	default:
+		// A parenthesized operand only breaks once the chain it is part of has
+		owner := b.owner
+
+		if b.chain >= 0 {
+			b.owner = b.chain
+		}
+
+		b.expression(open+1, close-1, depth+1)
+		b.owner = owner
+		return
+	}
+
+	group.Penalty += depth * PENALTY_NESTING
+	separators := b.separators(open+1, close-1, isItemSeparator)
+	owner := b.owner
+
+	// A call that is an operand of a chain of operators only breaks once the chain has,
+	// unless the chain is an assignment, whose right side is often a call of its own.
+	// Breaking the call costs breaking the chain on top of its own penalty, so that a
+	// chain broken in fill mode breaks at its operators before it breaks in the call.
+	if isCall && b.chain >= 0 && b.precedence > PrecedenceAssignment {
+		b.owner = b.chain
+		group.Penalty += b.groups[b.chain].Penalty
+	}
+
+	if group.HasClosing && b.canBreakBefore(open+1, close-1) {
+		group.Breaks = append(group.Breaks, open+1)
+		group.Kept = append(group.Kept, open+1)
+	}
+
+	for _, separator := range separators {
+		if b.canBreakBefore(separator+1, close-1) {
+			group.Breaks = append(group.Breaks, separator+1)
+
+			if isTable && b.tokens[separator].Token.hasNewLines() {
+				group.Kept = append(group.Kept, separator+1)
+			}
+		}
+	}
+
+	if group.HasClosing && open+1 < close {
+		group.Breaks = append(group.Breaks, close)
+		group.Kept = append(group.Kept, close)
+	}
+
+	// Initializer lists laid out in rows in the input are tables, kept broken
+	if isTable && len(group.Kept) > 2 {
+		group.IsHard = true
+	}
+
+	// Aligned items that would not fit after the ( can all start on the next line, and
+	// are lined up with the first one there
+	if group.IsAligned {
+		if b.canBreakBefore(open+1, close-1) {
+			b.addGroup(LayoutGroup{Start: open, End: close, Breaks: []int{open + 1}, Penalty: group.Penalty, Mode: BreakModeEach})
+		}
+
+		group.Start = open + 1
+	}
+
+	chain := b.chain
+	b.chain = -1
+
+	if index := b.addGroup(group); index >= 0 {
+		b.owner = index
+	}
+
+	itemStart := open + 1
+
+	for _, separator := range separators {
+		b.expression(itemStart, separator-1, depth+1)
+		itemStart = separator + 1
+	}
+
+	b.expression(itemStart, close-1, depth+1)
+	b.owner = owner
+	b.chain = chain
+}
+
+type groupState int
+
+const (
+	groupStateUndecided groupState = iota
+	groupStateFlat
+	groupStateBroken
+)
+
+// An alignment is the column the lines of a broken aligned group start at, from the
+// token at Start to the one at End. Groups inside it indent their lines from there,
+// by the levels past Level.
+type alignment struct {
+	Start  int
+	End    int
+	Column int
+	Level  int
+}
+
+// A breakPoint is a token that can start a line in a group, with the next break point
+// of the group
+type breakPoint struct {
+	Group  int
+	Next   int
+	IsKept bool
+}
+
+type layoutSolver struct {
+	Tokens      []LayoutToken
+	Groups      []LayoutGroup
+	Limit       int
+	IndentWidth int
+	// Group indices by first token, and break points by token
+	Starting [][]int
+	Points   [][]breakPoint
+	// Widths of the tokens before each token, when none is broken
+	Widths []int
+	// Last token that has to fit on the line of each group when it is not broken
+	FitEnds []int
+}
+
+func newLayoutSolver(tokens []LayoutToken, groups []LayoutGroup, limit int, indentWidth int) *layoutSolver {
+	s := layoutSolver{
+		Tokens:      tokens,
+		Groups:      groups,
+		Limit:       limit,
+		IndentWidth: indentWidth,
+		Starting:    make([][]int, len(tokens)),
+		Points:      make([][]breakPoint, len(tokens)),
+		Widths:      make([]int, len(tokens)+1),
+		FitEnds:     make([]int, len(groups)),
+	}
+
+	for i, token := range tokens {
+		s.Widths[i+1] = s.Widths[i] + token.Space + token.EndColumn - token.Column
+	}
+
+	canStartLine := make([]bool, len(tokens)+1)
+	canStartLine[len(tokens)] = true
+
+	for i, group := range groups {
+		s.Starting[group.Start] = append(s.Starting[group.Start], i)
+
+		for j, index := range group.Breaks {
+			next := group.End + 1
+
+			if j+1 < len(group.Breaks) {
+				next = group.Breaks[j+1]
+			}
+
+			s.Points[index] = append(s.Points[index], breakPoint{i, next, slices.Contains(group.Kept, index)})
+			canStartLine[index] = true
+		}
+	}
+
+	for i, token := range tokens {
+		if token.NewLine {
+			canStartLine[i] = true
+		}
+	}
+
+	for i, group := range groups {
+		s.FitEnds[i] = group.End
+
+		for !canStartLine[s.FitEnds[i]+1] {
+			s.FitEnds[i]++
+		}
+	}
+
+	return &s
+}
+
+// Returns the columns of the tokens from start to end on a single line
+func (s *layoutSolver) width(start int, end int) int {
+	return s.Widths[end+1] - s.Widths[start] - s.Tokens[start].Space
+}
+
+// Returns the cost of the tokens before stop in the layout where the groups are in the
+// given states. Undecided groups are broken only if they would not fit on their line.
+// Groups that start after stop do not change the cost, so it is a lower bound of the
+// cost of the whole statement whatever their states. If layout is not nil, it is
+// filled with the line breaks and indentation levels of the tokens.
+func (s *layoutSolver) evaluate(states []groupState, stop int, layout *Layout) int {
+	states = slices.Clone(states)
+	levels := make([]int, len(s.Tokens)+1)
+	alignments := []alignment{}
+	used := make([]bool, len(s.Groups))
+	cost := 0
+	level := 0
+	column := 0
+
+	for i, token := range s.Tokens[:stop] {
+		level += levels[i]
+		isBroken := false
+		alignedColumn := 0
+
+		for len(alignments) > 0 && alignments[len(alignments)-1].End < i {
+			alignments = alignments[:len(alignments)-1]
+		}
+
+		for _, point := range s.Points[i] {
+			if states[point.Group] != groupStateBroken || token.NewLine || i == 0 {
+				continue
+			}
+
+			if s.Groups[point.Group].Mode == BreakModeEach || point.IsKept ||
+				column+token.Space+s.width(i, s.fitEnd(point)) > s.Limit {
+				isBroken = true
+				used[point.Group] = true
+			}
+		}
+
+		switch {
+		case i == 0:
+			column = token.Column
+		case token.NewLine:
+			column = token.Column + level*s.IndentWidth
+		case isBroken:
+			column = token.LineIndent + level*s.IndentWidth
+			cost += PENALTY_LINE_BREAK
+		default:
+			column += token.Space
+		}
+
+		if n := len(alignments); i > 0 && (token.NewLine || isBroken) && n > 0 && alignments[n-1].Start <= i {
+			alignedColumn = alignments[n-1].Column + (level-alignments[n-1].Level)*s.IndentWidth
+			column = alignedColumn
+		}
+
+		for _, index := range s.Starting[i] {
+			group := s.Groups[index]
+
+			if group.Owner >= 0 && states[group.Owner] != groupStateBroken {
+				states[index] = groupStateFlat
+			}
+
+			if states[index] == groupStateUndecided {
+				states[index] = groupStateFlat
+
+				if column+s.width(i, s.FitEnds[index]) > s.Limit {
+					states[index] = groupStateBroken
+				}
+			}
+
+			if states[index] == groupStateBroken {
+				if layout != nil {
+					layout.Groups[i] = true
+				}
+
+				end := group.End
+
+				if group.HasClosing {
+					end--
+				}
+
+				if group.IsAligned {
+					alignments = append(alignments, alignment{
+						Start:  group.Breaks[0],
+						End:    end,
+						Column: column,
+						Level:  level,
+					})
+				} else {
+					levels[group.Breaks[0]]++
+					levels[end+1]--
+				}
+			}
+		}
+
+		end := column + token.EndColumn - token.Column
+
+		if token.Multiline {
+			end = token.EndColumn
+		}
+
+		// The formatter splits a literal that does not fit with the punctuation after it,
+		// its pieces after the first one starting a line one level further
+		trailing := 0
+
+		for j := i + 1; token.CanSplit && j < len(s.Tokens) && isTrailingPunctuation(s.Tokens[j].Token) &&
+			len(s.Points[j]) == 0; j++ {
+			trailing += s.Tokens[j].EndColumn - s.Tokens[j].Column
+		}
+
+		if token.CanSplit && end+trailing > s.Limit {
+			continuation := token.LineIndent + (level+1)*s.IndentWidth
+
+			if alignedColumn > 0 {
+				continuation = alignedColumn + s.IndentWidth
+			}
+
+			pieces := splitStringLiteral(token.Token.Content, column, continuation, s.Limit, trailing)
+
+			for j, piece := range pieces[:len(pieces)-1] {
+				if j > 0 {
+					column = continuation
+				}
+
+				if pieceEnd := column + utf8.RuneCountInString(piece); pieceEnd > s.Limit {
+					cost += (pieceEnd - max(column, s.Limit)) * PENALTY_EXCESS_CHARACTER
+				}
+
+				cost += PENALTY_LINE_BREAK
+			}
+
+			if len(pieces) > 1 {
+				column = continuation
+			}
+
+			end = column + utf8.RuneCountInString(pieces[len(pieces)-1])
+		}
+
+		if end > s.Limit && !s.isTrailingComment(i) {
+			cost += (end - max(column, s.Limit)) * PENALTY_EXCESS_CHARACTER
+		}
+
+		column = end
+
+		if layout != nil && !token.IsDirective {
+			layout.Breaks[i] = isBroken
+			layout.Levels[i] = level
+			layout.Columns[i] = alignedColumn
+		}
+	}
+
+	for i, isUsed := range used {
+		if isUsed {
+			cost += s.Groups[i].Penalty
+		}
+	}
+
+	return cost
+}
+
+// Whether the token at index is a comment trailing code, which never makes the code
+// break: an overlong trailing comment stays where it is rather than splitting the line
+func (s *layoutSolver) isTrailingComment(index int) bool {
+	token := s.Tokens[index]
+
+	return token.Token.isComment() && !token.NewLine
+}
+
+// Returns the last token of the part of a group that starts at a break point
+func (s *layoutSolver) fitEnd(point breakPoint) int {
+	if point.Next > s.Groups[point.Group].End {
+		return s.FitEnds[point.Group]
+	}
+
+	return point.Next - 1
+}
+
+// Decides whether to break each group, outer groups first, by comparing the cost of
+// the statement with the group broken and with it on one line, the groups after it
+// being broken only if they do not fit
+func (s *layoutSolver) greedy() []groupState {
+	states := make([]groupState, len(s.Groups))
+
+	for i, group := range s.Groups {
+		if group.IsHard {
+			states[i] = groupStateBroken
+			continue
+		}
+
+		states[i] = groupStateFlat
+		flat := s.evaluate(states, len(s.Tokens), nil)
+		states[i] = groupStateBroken
+		broken := s.evaluate(states, len(s.Tokens), nil)
+
+		if flat <= broken {
+			states[i] = groupStateFlat
+		}
+	}
+
+	return states
+}
+
+// Returns the states of the groups of the cheapest layout of the statement. The states
+// are searched jointly, outer groups first, leaving out the groups whose owner is flat
+// and the choices whose tokens before the next group already cost more than the best
+// layout found. The search starts from the greedy layout, and keeps the best one found
+// once it has tried LAYOUT_SEARCH_LIMIT layouts, whole or in part.
+func (s *layoutSolver) solve() []groupState {
+	best := s.greedy()
+	bestCost := s.evaluate(best, len(s.Tokens), nil)
+	states := make([]groupState, len(s.Groups))
+	budget := LAYOUT_SEARCH_LIMIT
+
+	var search func(index int)
+
+	search = func(index int) {
+		if budget == 0 {
+			return
+		}
+
+		budget--
+
+		if index == len(s.Groups) {
+			if cost := s.evaluate(states, len(s.Tokens), nil); cost < bestCost {
+				best, bestCost = slices.Clone(states), cost
+			}
+
+			return
+		}
+
+		group := s.Groups[index]
+		choices := []groupState{groupStateFlat, groupStateBroken}
+
+		switch {
+		case group.IsHard:
+			choices = choices[1:]
+		case group.Owner >= 0 && states[group.Owner] != groupStateBroken:
+			choices = choices[:1]
+		}
+
+		stop := len(s.Tokens)
+
+		if index+1 < len(s.Groups) {
+			stop = s.Groups[index+1].Start
+		}
+
+		for _, state := range choices {
+			states[index] = state
+
+			if s.evaluate(states, stop, nil) < bestCost {
+				search(index + 1)
+			}
+		}
+
+		states[index] = groupStateUndecided
+	}
+
+	search(0)
+
+	return best
+}
+
+// Records how the current token was written, while the formatter measures a statement
+// before breaking it. column is the column of the token, and length the length of the
+// output before it.
+func (f *Formatter) measureToken(column int, length int) {
+	if !f.Measuring {
+		return
+	}
+
+	lineStart := bytes.LastIndexByte(f.Output[:length], '\n') + 1
+	before := string(f.Output[lineStart:length])
+
+	token := LayoutToken{
+		Token:       f.token(),
+		NodeType:    f.Node().Type,
+		IsNodeStart: f.isNodeStart(),
+		IsDirective: f.Node().isDirective() || f.isEndOfDirective(),
+		Column:      column,
+		EndColumn:   f.OutputColumn,
+		NewLine:     strings.TrimSpace(before) == "",
+		Multiline:   bytes.IndexByte(f.Output[length:], '\n') >= 0,
+		CanSplit:    f.token().isString() && f.splitsStrings(),
+	}
+
+	switch {
+	case token.NewLine:
+		token.LineIndent = column
+	case len(f.Measured) == 0:
+		indentation := before[:len(before)-len(strings.TrimLeft(before, " \t"))]
+		token.LineIndent = columnWidth(indentation, 0, f.Options.TabWidth)
+	default:
+		previous := f.Measured[len(f.Measured)-1]
+		token.Space = column - previous.EndColumn
+		token.LineIndent = previous.LineIndent
+	}
+
+	f.Measured = append(f.Measured, token)
+}
+
+// Lays out the statement measured since its start, and goes back to its start to
+// write it again with the line breaks of the cheapest layout
+func (f *Formatter) replayWithLayout(start *StatementStart) {
+	tokens := f.Measured
+	layout := Layout{
+		Start:   start.Formatter.TokenIndex + 1,
+		Breaks:  make([]bool, len(tokens)),
+		Levels:  make([]int, len(tokens)),
+		Columns: make([]int, len(tokens)),
+		Groups:  make([]bool, len(tokens)),
+	}
+
+	if len(tokens) > 0 {
+		groups := buildLayoutGroups(tokens, f.Options.ArgumentWrapping, f.Options.ParameterWrapping)
+		solver := newLayoutSolver(tokens, groups, f.Options.ColumnLimit, f.Options.IndentWidth)
+		solver.evaluate(solver.solve(), len(tokens), &layout)
+	}
+
+	f.restore(start)
+	f.Layout = &layout
+}
+
+// Makes room in the layout of the statement for the pieces that replace count tokens
+// at index, as the lines of a reflowed comment. If continues is true, the pieces after
+// the first one continue it one level further, as the pieces of a split string literal.
+func (f *Formatter) replaceLayoutTokens(index int, count int, pieces int, continues bool) {
+	if f.Layout == nil {
+		return
+	}
+
+	i := index - f.Layout.Start
+
+	if i < 0 || i >= len(f.Layout.Levels) {
+		return
+	}
+
+	count = min(count, len(f.Layout.Levels)-i)
+	breaks := make([]bool, pieces)
+	levels := make([]int, pieces)
+	columns := make([]int, pieces)
+	groups := make([]bool, pieces)
+	breaks[0], groups[0] = f.Layout.Breaks[i], f.Layout.Groups[i]
+
+	for j := range levels {
+		levels[j] = f.Layout.Levels[i]
+		columns[j] = f.Layout.Columns[i]
+
+		if continues && j > 0 {
+			levels[j]++
+
+			if columns[j] > 0 {
+				columns[j] += f.Options.IndentWidth
+			}
+		}
+	}
+
+	f.Layout.Breaks = slices.Replace(f.Layout.Breaks, i, i+count, breaks...)
+	f.Layout.Levels = slices.Replace(f.Layout.Levels, i, i+count, levels...)
+	f.Layout.Columns = slices.Replace(f.Layout.Columns, i, i+count, columns...)
+	f.Layout.Groups = slices.Replace(f.Layout.Groups, i, i+count, groups...)
+}
+
+// Whether the layout of the statement breaks the group that starts at index, as an
+// initializer list
+func (f *Formatter) breaksGroup(index int) bool {
+	if f.Layout == nil {
+		return false
+	}
+
+	i := index - f.Layout.Start
+
+	return i >= 0 && i < len(f.Layout.Groups) && f.Layout.Groups[i]
+}
+
+// Whether the layout of the statement starts a line at the next token
+func (f *Formatter) breaksLine() bool {
+	if f.Layout == nil {
+		return false
+	}
+
+	i := f.TokenIndex + 1 - f.Layout.Start
+
+	return i >= 0 && i < len(f.Layout.Breaks) && f.Layout.Breaks[i]
+}
+
+// Returns the number of levels the layout of the statement indents the next token past
+// the statement, if it starts a line
+func (f *Formatter) layoutLevel() int {
+	if f.Layout == nil {
+		return 0
+	}
+
+	i := f.TokenIndex + 1 - f.Layout.Start
+
+	if i < 0 || i >= len(f.Layout.Levels) {
+		return 0
+	}
+
+	return f.Layout.Levels[i]
+}
+
+// Returns the column the layout of the statement lines the next token up at, if it
+// starts a line after a bracket it is aligned with, or 0
+func (f *Formatter) layoutColumn() int {
+	if f.Layout == nil {
+		return 0
+	}
+
+	i := f.TokenIndex + 1 - f.Layout.Start
+
+	if i < 0 || i >= len(f.Layout.Columns) {
+		return 0
+	}
+
+	return f.Layout.Columns[i]
+}
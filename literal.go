@@ -0,0 +1,203 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encoding prefixes of string literals, u8 before u
+var STRING_PREFIXES = [...]string{"u8", "u", "U", "L", ""}
+
+// The parts of the content of a string literal that are never split: escape sequences,
+// printf format specifiers and single characters
+var stringUnit = regexp.MustCompile(`\\(x[0-9a-fA-F]+|u[0-9a-fA-F]{4}|U[0-9a-fA-F]{8}|[0-7]{1,3}|.)|%%|%[-+ #0]*(\*|[0-9]+)?(\.(\*|[0-9]+))?(hh|h|ll|l|j|z|t|L)?[diouxXfFeEgGaAcspn]|.`)
+
+// Returns the encoding prefix of the string literal that starts text, as L of L"...",
+// or false if text does not start with one
+func stringPrefix(text string) (string, bool) {
+	for _, prefix := range STRING_PREFIXES {
+		if strings.HasPrefix(text, prefix) && len(text) > len(prefix) && isDoubleQuote(rune(text[len(prefix)])) {
+			return prefix, true
+		}
+	}
+
+	return "", false
+}
+
+// Whether a literal can be split after its unit at index, at the end of a run of
+// spaces or after a new line
+func isStringBreak(units []string, index int) bool {
+	return (units[index] == " " && units[index+1] != " ") || units[index] == `\n`
+}
+
+// Splits the content of a string literal into pieces of at most width columns, the
+// first one of at most firstWidth, breaking it between words. The last piece leaves
+// trailing columns for what follows the literal on its line. A piece holds at least
+// one word, even if it is longer, and an empty content is a single empty piece.
+func splitStringContent(content string, firstWidth int, width int, trailing int) []string {
+	units := stringUnit.FindAllString(content, -1)
+
+	if len(units) == 0 {
+		return []string{""}
+	}
+
+	result := []string{}
+
+	for start := 0; start < len(units); {
+		maxWidth := width
+
+		if len(result) == 0 {
+			maxWidth = firstWidth
+		}
+
+		end := start
+		column := 0
+
+		for i := start; i < len(units); i++ {
+			column += utf8.RuneCountInString(units[i])
+
+			if (column > maxWidth || (i == len(units)-1 && column+trailing > maxWidth)) && end > start {
+				break
+			}
+
+			if i == len(units)-1 || isStringBreak(units, i) {
+				end = i + 1
+			}
+		}
+
+		result = append(result, strings.Join(units[start:end], ""))
+		start = end
+	}
+
+	return result
+}
+
+// Returns the column of the lines that continue a statement, or of the pieces of the
+// current token in the layout of the statement
+func (f *Formatter) continuationColumn() int {
+	level := f.Indent + 1
+
+	if f.Layout != nil {
+		if i := f.TokenIndex - f.Layout.Start; i >= 0 && i < len(f.Layout.Levels) {
+			if f.Layout.Columns[i] > 0 {
+				return f.Layout.Columns[i] + f.Options.IndentWidth
+			}
+
+			level += f.Layout.Levels[i]
+		}
+	}
+
+	return level * f.Options.IndentWidth
+}
+
+// Whether the next token is a string literal that continues the current one on a line
+// of its own, either a piece of a split literal or one that was written that way
+func (f *Formatter) continuesString() bool {
+	return f.nextToken().Split ||
+		(f.splitsStrings() && f.token().isString() && f.token().hasUnescapedLines() && f.nextToken().isString())
+}
+
+// Splits a string literal that starts at column into adjacent literals that fit the
+// column limit, the ones after the first starting at continuation, and the last one
+// followed by trailing columns
+func splitStringLiteral(literal string, column int, continuation int, limit int, trailing int) []string {
+	prefix, _ := stringPrefix(literal)
+	quotes := len(prefix) + len(`""`)
+	content := literal[len(prefix)+1 : len(literal)-1]
+	result := []string{}
+
+	for _, piece := range splitStringContent(content, limit-column-quotes, limit-continuation-quotes, trailing) {
+		result = append(result, prefix+`"`+piece+`"`)
+	}
+
+	return result
+}
+
+// Splits the string literal at the current token into adjacent literals that fit the
+// column limit, each on a line of its own. The pieces of an earlier split are joined
+// again first, since the formatter goes back to the start of a statement to lay it out,
+// and the literal may then start at another column. While the statement is measured,
+// the literal stays whole, and the layout splits it where it does not fit its line.
+// Otherwise, wrapping the line is tried first, unless the literal would not fit on a
+// line of its own either.
+func (f *Formatter) splitString() {
+	if !f.token().isString() || f.token().Split || !f.splitsStrings() {
+		return
+	}
+
+	end := f.TokenIndex + 1
+
+	for f.tokenAt(end).Split {
+		end++
+	}
+
+	// The punctuation is read first, since reading tokens can grow the slice
+	trailing := f.trailingPunctuationWidth(end)
+	tokens := *f.Tokens
+	prefix, _ := stringPrefix(f.token().Content)
+	var content strings.Builder
+
+	for _, token := range tokens[f.TokenIndex:end] {
+		content.WriteString(token.Content[len(prefix)+1 : len(token.Content)-1])
+	}
+
+	literal := prefix + `"` + content.String() + `"`
+	length := utf8.RuneCountInString(literal) + trailing
+	limit := f.Options.ColumnLimit
+	pieces := []string{literal}
+
+	switch {
+	case f.Measuring:
+	case f.Layout != nil:
+		if f.OutputColumn+length > limit {
+			pieces = splitStringLiteral(literal, f.OutputColumn, f.continuationColumn(), limit, trailing)
+		}
+	default:
+		if f.OutputColumn+length > limit && f.continuationColumn()+length > limit {
+			pieces = splitStringLiteral(literal, f.OutputColumn, f.continuationColumn(), limit, trailing)
+		}
+	}
+
+	if end == f.TokenIndex+1 && len(pieces) == 1 {
+		return
+	}
+
+	block := []Token{}
+
+	for i, piece := range pieces {
+		token := tokens[f.TokenIndex]
+		token.Content = piece
+		token.Whitespace = Whitespace{NewLines: 1, HasUnescapedLines: true}
+		token.Split = i > 0
+		block = append(block, token)
+	}
+
+	block[len(block)-1].Whitespace = tokens[end-1].Whitespace
+	*f.Tokens = slices.Replace(tokens, f.TokenIndex, end, block...)
+	f.replaceLayoutTokens(f.TokenIndex, end-f.TokenIndex, len(block), true)
+}
+
+// Returns the width of the commas, closing parentheses and semicolons from index on,
+// which stay on the line of the literal before them unless the layout breaks it
+func (f *Formatter) trailingPunctuationWidth(index int) int {
+	width := 0
+
+	for i := index; isTrailingPunctuation(f.tokenAt(i)); i++ {
+		if f.Layout != nil {
+			if j := i - f.Layout.Start; j >= 0 && j < len(f.Layout.Breaks) && f.Layout.Breaks[j] {
+				break
+			}
+		}
+
+		width += utf8.RuneCountInString(f.tokenAt(i).Content)
+	}
+
+	return width
+}
+
+// Whether a token stays right after a string literal, as the , ) or ; after it
+func isTrailingPunctuation(token Token) bool {
+	return token.isComma() || token.isRightParenthesis() || token.isSemicolon()
+}
@@ -13,6 +13,10 @@ type Node struct {
 	BlockType             BlockType
 	DirectiveType         DirectiveType
 	RightSideOfAssignment bool
+	InCaseLabel           bool
+	InCaseBody            bool
+	CaseLabelEnd          int
+	BraceStyle            BraceStyle
 }
 
 type NodeType int
@@ -28,6 +32,9 @@ const (
 	NodeTypeStructOrUnion
 	NodeTypeEnum
 	NodeTypeForLoopParenthesis
+	NodeTypeLinkageSpecification
+	NodeTypeSwitch
+	NodeTypeImplicitBlock
 	NodeTypeCount
 )
 
@@ -36,6 +43,7 @@ type BlockType int
 const (
 	BlockTypeNone BlockType = iota
 	BlockTypeDoWhile
+	BlockTypeIf
 )
 
 func (t NodeType) String() string {
@@ -62,6 +70,12 @@ func (t NodeType) String() string {
 		return "NodeTypeEnum"
 	case NodeTypeForLoopParenthesis:
 		return "NodeTypeForLoopParenthesis"
+	case NodeTypeLinkageSpecification:
+		return "NodeTypeLinkageSpecification"
+	case NodeTypeSwitch:
+		return "NodeTypeSwitch"
+	case NodeTypeImplicitBlock:
+		return "NodeTypeImplicitBlock"
 	default:
 		panic(fmt.Sprintf("Unexpected node type %d", t))
 	}
@@ -76,6 +90,15 @@ func (n Node) isTopLevel() bool {
 	return n.Type == NodeTypeTopLevel
 }
 
+func (n Node) isLinkageSpecification() bool {
+	return n.Type == NodeTypeLinkageSpecification
+}
+
+// Declarations inside extern "C" { } follow the same rules as top level declarations
+func (n Node) isDeclarationScope() bool {
+	return n.isTopLevel() || n.isLinkageSpecification()
+}
+
 func (n Node) isDirective() bool {
 	return n.Type == NodeTypeDirective
 }
@@ -84,8 +107,17 @@ func (n Node) isStructOrUnion() bool {
 	return n.Type == NodeTypeStructOrUnion
 }
 
+// The body of a switch is a block whose statements can be preceded by case labels
 func (n Node) isBlock() bool {
-	return n.Type == NodeTypeBlock
+	return n.Type == NodeTypeBlock || n.Type == NodeTypeSwitch
+}
+
+func (n Node) isImplicitBlock() bool {
+	return n.Type == NodeTypeImplicitBlock
+}
+
+func (n Node) isSwitch() bool {
+	return n.Type == NodeTypeSwitch
 }
 
 func (n Node) isEnum() bool {
@@ -0,0 +1,216 @@
+package main
+
+import "fmt"
+
+type Options struct {
+	IndentWidth           int
+	UseTabs               bool
+	TabWidth              int
+	ColumnLimit           int
+	DirectiveIndent       DirectiveIndent
+	IndentIncludeGuard    bool
+	IndentCaseLabels      bool
+	GotoLabelIndent       LabelIndent
+	InsertBraces          bool
+	FunctionBraces        BraceStyle
+	ControlBraces         BraceStyle
+	TypeBraces            BraceStyle
+	InitializerBraces     BraceStyle
+	EndOfLine             EndOfLine
+	InsertFinalNewline    bool
+	Charset               Charset
+	PointerAlignment      PointerAlignment
+	ArgumentWrapping      ArgumentWrapping
+	ParameterWrapping     ArgumentWrapping
+	AlignDeclarations     bool
+	AlignAssignments      bool
+	AlignMacros           bool
+	AlignStructMembers    bool
+	AlignTrailingComments bool
+	TrailingCommentGap    int
+	AlignEnumValues       bool
+	EnumTrailingComma     TrailingComma
+	SortIncludes          bool
+	IncludeCategories     string
+	DeduplicatePragmas    bool
+	SortPragmaLibraries   bool
+	HeaderGuard           HeaderGuard
+	HeaderGuardTemplate   string
+	VerbatimBlockComments bool
+	ReflowComments        bool
+	SplitStrings          bool
+	StrictWidth           bool
+}
+
+type DirectiveIndent int
+
+const (
+	DirectiveIndentNone DirectiveIndent = iota
+	DirectiveIndentAfterHash
+	DirectiveIndentBeforeHash
+)
+
+type DirectiveIndentName struct {
+	Name            string
+	DirectiveIndent DirectiveIndent
+}
+
+var directiveIndentNames = [...]DirectiveIndentName{
+	{"none", DirectiveIndentNone},
+	{"after-hash", DirectiveIndentAfterHash},
+	{"before-hash", DirectiveIndentBeforeHash},
+}
+
+type EndOfLine int
+
+const (
+	EndOfLineLf EndOfLine = iota
+	EndOfLineCrlf
+	EndOfLineCr
+)
+
+type EndOfLineName struct {
+	Name      string
+	EndOfLine EndOfLine
+	NewLine   string
+}
+
+var endOfLineNames = [...]EndOfLineName{
+	{"lf", EndOfLineLf, "\n"},
+	{"crlf", EndOfLineCrlf, "\r\n"},
+	{"cr", EndOfLineCr, "\r"},
+}
+
+// Charset only controls the byte order mark, the input is always read as UTF-8
+type Charset int
+
+const (
+	CharsetKeep Charset = iota
+	CharsetUtf8
+	CharsetUtf8Bom
+)
+
+type CharsetName struct {
+	Name    string
+	Charset Charset
+}
+
+var charsetNames = [...]CharsetName{
+	{"keep", CharsetKeep},
+	{"utf-8", CharsetUtf8},
+	{"utf-8-bom", CharsetUtf8Bom},
+}
+
+const BYTE_ORDER_MARK = "\ufeff"
+
+func defaultOptions() Options {
+	return Options{
+		IndentWidth:           4,
+		UseTabs:               false,
+		TabWidth:              4,
+		ColumnLimit:           MAX_COLUMNS,
+		DirectiveIndent:       DirectiveIndentNone,
+		IndentIncludeGuard:    false,
+		IndentCaseLabels:      true,
+		GotoLabelIndent:       LabelIndentNone,
+		InsertBraces:          false,
+		FunctionBraces:        BraceStyleAttach,
+		ControlBraces:         BraceStyleAttach,
+		TypeBraces:            BraceStyleAttach,
+		InitializerBraces:     BraceStyleAttach,
+		EndOfLine:             EndOfLineLf,
+		InsertFinalNewline:    true,
+		Charset:               CharsetKeep,
+		PointerAlignment:      PointerAlignmentRight,
+		ArgumentWrapping:      ArgumentWrappingOnePerLine,
+		ParameterWrapping:     ArgumentWrappingOnePerLine,
+		AlignDeclarations:     false,
+		AlignAssignments:      false,
+		AlignMacros:           false,
+		AlignStructMembers:    false,
+		AlignTrailingComments: false,
+		TrailingCommentGap:    1,
+		AlignEnumValues:       false,
+		EnumTrailingComma:     TrailingCommaKeep,
+		SortIncludes:          false,
+		IncludeCategories:     "",
+		DeduplicatePragmas:    false,
+		SortPragmaLibraries:   false,
+		HeaderGuard:           HeaderGuardKeep,
+		HeaderGuardTemplate:   "{FILE}_{EXT}",
+		VerbatimBlockComments: false,
+		ReflowComments:        false,
+		SplitStrings:          false,
+		StrictWidth:           false,
+	}
+}
+
+func parseDirectiveIndent(name string) (DirectiveIndent, error) {
+	for _, n := range directiveIndentNames {
+		if n.Name == name {
+			return n.DirectiveIndent, nil
+		}
+	}
+
+	return DirectiveIndentNone, fmt.Errorf("invalid directive indentation: %s", name)
+}
+
+func (d DirectiveIndent) String() string {
+	for _, n := range directiveIndentNames {
+		if n.DirectiveIndent == d {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected directive indentation %d", d))
+}
+
+func parseEndOfLine(name string) (EndOfLine, error) {
+	for _, n := range endOfLineNames {
+		if n.Name == name {
+			return n.EndOfLine, nil
+		}
+	}
+
+	return EndOfLineLf, fmt.Errorf("invalid end of line: %s", name)
+}
+
+func (e EndOfLine) String() string {
+	for _, n := range endOfLineNames {
+		if n.EndOfLine == e {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected end of line %d", e))
+}
+
+func (e EndOfLine) newLine() string {
+	for _, n := range endOfLineNames {
+		if n.EndOfLine == e {
+			return n.NewLine
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected end of line %d", e))
+}
+
+func parseCharset(name string) (Charset, error) {
+	for _, n := range charsetNames {
+		if n.Name == name {
+			return n.Charset, nil
+		}
+	}
+
+	return CharsetKeep, fmt.Errorf("invalid charset: %s", name)
+}
+
+func (c Charset) String() string {
+	for _, n := range charsetNames {
+		if n.Charset == c {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected charset %d", c))
+}
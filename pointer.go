@@ -0,0 +1,215 @@
+package main
+
+import "fmt"
+
+type PointerAlignment int
+
+const (
+	PointerAlignmentRight PointerAlignment = iota
+	PointerAlignmentLeft
+	PointerAlignmentMiddle
+)
+
+type PointerAlignmentName struct {
+	Name             string
+	PointerAlignment PointerAlignment
+}
+
+var pointerAlignmentNames = [...]PointerAlignmentName{
+	{"right", PointerAlignmentRight},
+	{"left", PointerAlignmentLeft},
+	{"middle", PointerAlignmentMiddle},
+}
+
+func parsePointerAlignment(name string) (PointerAlignment, error) {
+	for _, n := range pointerAlignmentNames {
+		if n.Name == name {
+			return n.PointerAlignment, nil
+		}
+	}
+
+	return PointerAlignmentRight, fmt.Errorf("invalid pointer alignment: %s", name)
+}
+
+func (p PointerAlignment) String() string {
+	for _, n := range pointerAlignmentNames {
+		if n.PointerAlignment == p {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected pointer alignment %d", p))
+}
+
+func (t Token) isTypeKeyword() bool {
+	switch t.KeywordType {
+	case KeywordTypeVoid, KeywordTypeChar, KeywordTypeShort, KeywordTypeInt, KeywordTypeLong,
+		KeywordTypeFloat, KeywordTypeDouble, KeywordTypeSigned, KeywordTypeUnsigned, KeywordTypeBool,
+		KeywordTypeComplex, KeywordTypeImaginary, KeywordTypeInt8, KeywordTypeInt16, KeywordTypeInt32,
+		KeywordTypeInt64:
+		return t.Type == TokenTypeKeyword
+	default:
+		return false
+	}
+}
+
+func (t Token) isTypeQualifier() bool {
+	switch t.KeywordType {
+	case KeywordTypeConst, KeywordTypeVolatile, KeywordTypeRestrict, KeywordTypeAtomic:
+		return t.Type == TokenTypeKeyword
+	default:
+		return false
+	}
+}
+
+// Keywords that can precede the type name of a declaration
+func (t Token) isDeclarationSpecifier() bool {
+	switch t.KeywordType {
+	case KeywordTypeStatic, KeywordTypeExtern, KeywordTypeRegister, KeywordTypeInline, KeywordTypeTypedef,
+		KeywordTypeAuto, KeywordTypeThreadLocal:
+		return t.Type == TokenTypeKeyword
+	default:
+		return t.isTypeKeyword() || t.isTypeQualifier()
+	}
+}
+
+// Whether the * or & at index belongs to a declaration or a type name, as in char *p
+// or (char *)p, rather than being a unary or binary operator. An identifier followed
+// by * is taken to be a type name where an expression statement would make no sense,
+// or after another identifier, which can only be a macro, as in PyObject_HEAD. The
+// decision only depends on the tokens, so that formatting the output again keeps it.
+func (f *Formatter) isPointerDeclarator(index int) bool {
+	token := f.tokenAt(index)
+
+	if !token.canBePointerOperator() {
+		return false
+	}
+
+	previous := f.tokenAt(index - 1)
+
+	if previous.canBePointerOperator() {
+		return f.isPointerDeclarator(index - 1)
+	}
+
+	if previous.isTypeKeyword() || previous.isTypeQualifier() {
+		return true
+	}
+
+	if !previous.isIdentifier() {
+		return false
+	}
+
+	next := index + 1
+
+	for f.tokenAt(next).canBePointerOperator() || f.tokenAt(next).isTypeQualifier() {
+		next++
+	}
+
+	if f.tokenAt(next).isRightParenthesis() {
+		return true
+	}
+
+	beforeType := f.tokenAt(index - 2)
+
+	if beforeType.isStructOrUnion() || beforeType.isEnum() || beforeType.isDeclarationSpecifier() {
+		return true
+	}
+
+	switch {
+	case f.isParameterList():
+		return beforeType.isLeftParenthesis() || beforeType.isComma() || beforeType.isComment() ||
+			beforeType.isIdentifier() || f.endsDirective(index-2)
+	case f.Node().isForLoopParenthesis():
+		return beforeType.isLeftParenthesis()
+	case f.Node().isDirective(), f.Node().isFuncOrMacro(), f.Node().isInitializerList():
+		return false
+	default:
+		return beforeType.isAbsent() || beforeType.isSemicolon() || beforeType.isLeftBrace() ||
+			beforeType.isRightBrace() || beforeType.isComment() || beforeType.isIdentifier() ||
+			f.endsDirective(index-2) || f.isGotoLabel(index-3) ||
+			(f.isInsideSwitchBody() && f.Node().CaseLabelEnd == index-2)
+	}
+}
+
+// Whether the token at index is the last one of a directive, which ends at a new line
+// that is not escaped
+func (f *Formatter) endsDirective(index int) bool {
+	if !f.tokenAt(index).hasUnescapedLines() {
+		return false
+	}
+
+	for i := index; i >= 0; i-- {
+		if f.tokenAt(i).isDirective() {
+			return true
+		}
+
+		if i < index && f.tokenAt(i).hasUnescapedLines() {
+			return false
+		}
+	}
+
+	return false
+}
+
+// Whether the current node holds the parameters of a function definition, or of a
+// function declaration, which is parsed as a call when it is inside a block
+func (f *Formatter) isParameterList() bool {
+	if f.Node().isFuncOrMacroDef() {
+		return true
+	}
+
+	if !f.Node().isFuncOrMacro() || f.OpenParenthesis != f.Node().InitialParenthesis {
+		return false
+	}
+
+	returnType := f.Node().FirstToken - 2
+
+	return f.tokenAt(returnType).isIdentifier() ||
+		f.tokenAt(returnType).isTypeKeyword() ||
+		f.isPointerDeclarator(returnType)
+}
+
+// Whether the ) at index closes a cast to a type ending with a keyword or a pointer
+func (f *Formatter) isCastEnd(index int) bool {
+	if !f.tokenAt(index).isRightParenthesis() {
+		return false
+	}
+
+	last := f.tokenAt(index - 1)
+	owner := f.parenthesisOwner(index)
+
+	return (last.isTypeKeyword() || f.isPointerDeclarator(index-1)) &&
+		!owner.isIdentifier() && !owner.isRightParenthesis() && !owner.isRightBracket() &&
+		!owner.isSizeOf() && owner.KeywordType != KeywordTypeAlignof &&
+		!owner.isIf() && !owner.isWhile() && !owner.isFor() && !owner.isSwitch()
+}
+
+func (f *Formatter) isUnaryPointerOperator(index int) bool {
+	previous := f.tokenAt(index - 1)
+
+	return f.tokenAt(index).canBePointerOperator() &&
+		!f.isPointerDeclarator(index) &&
+		(!previous.canBeLeftOperand() || f.isCastEnd(index-1)) &&
+		!previous.isRightBracket() &&
+		!previous.isString()
+}
+
+// Whether there is no space after the current * or &
+func (f *Formatter) isPointerOperator() bool {
+	if f.isUnaryPointerOperator(f.TokenIndex) {
+		return true
+	}
+
+	if !f.isPointerDeclarator(f.TokenIndex) {
+		return false
+	}
+
+	return f.Options.PointerAlignment == PointerAlignmentRight || f.nextToken().canBePointerOperator()
+}
+
+// Whether there is no space between the current token and a following * or &
+func (f *Formatter) beforeLeftAlignedPointer() bool {
+	return f.Options.PointerAlignment == PointerAlignmentLeft &&
+		f.isPointerDeclarator(f.TokenIndex+1) &&
+		!f.token().canBePointerOperator()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// A PragmaLine is the range of tokens of a #pragma line. Text is its content after
+// #pragma, trailing comment included, and Library is the name given to
+// comment(lib, "name"), if it is one.
+type PragmaLine struct {
+	TokenRange
+	Text    string
+	Library string
+}
+
+func (f *Formatter) pragmaLine(index int) (PragmaLine, bool) {
+	if !f.tokenAt(index).isPragmaDirective() {
+		return PragmaLine{}, false
+	}
+
+	end, comment, found := f.directiveLine(index)
+
+	if !found {
+		return PragmaLine{}, false
+	}
+
+	words := []string{}
+
+	for i := index + 1; i < end; i++ {
+		words = append(words, f.tokenAt(i).Content)
+	}
+
+	library := ""
+	arguments := words[:comment-index-1]
+
+	if len(arguments) == 6 &&
+		arguments[0] == "comment" && arguments[1] == "(" && arguments[2] == "lib" && arguments[3] == "," &&
+		f.tokenAt(index+5).isString() && arguments[5] == ")" {
+		library = arguments[4]
+	}
+
+	return PragmaLine{TokenRange{index, end}, strings.Join(words, " "), library}, true
+}
+
+// Whether removing a copy of the pragma could change the meaning of the code, as with
+// #pragma pack(push, 1) or #pragma warning(pop)
+func (p PragmaLine) isStacked() bool {
+	for _, word := range strings.Fields(p.Text) {
+		if word == "push" || word == "pop" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *Formatter) isPragmaBlockStart() bool {
+	return f.token().isPragmaDirective() &&
+		!(f.LastPop.isPragmaDirective() && f.LastPop.LastToken == f.TokenIndex-1)
+}
+
+// Removes the #pragma lines that repeat an earlier line of the same block, and sorts
+// the runs of consecutive #pragma comment(lib, ...) lines, ignoring case
+func (f *Formatter) updatePragmas() {
+	if !(f.Options.DeduplicatePragmas || f.Options.SortPragmaLibraries) || !f.isPragmaBlockStart() {
+		return
+	}
+
+	lines := []PragmaLine{}
+
+	for index := f.TokenIndex; ; {
+		line, found := f.pragmaLine(index)
+
+		if !found {
+			break
+		}
+
+		lines = append(lines, line)
+		index = line.End
+	}
+
+	if len(lines) < 2 {
+		return
+	}
+
+	result := []PragmaLine{}
+
+	for _, line := range lines {
+		isDuplicate := slices.ContainsFunc(result, func(previous PragmaLine) bool {
+			return previous.Text == line.Text
+		})
+
+		if !(f.Options.DeduplicatePragmas && isDuplicate && !line.isStacked()) {
+			result = append(result, line)
+		}
+	}
+
+	for runStart := 0; f.Options.SortPragmaLibraries && runStart < len(result); {
+		runEnd := runStart
+
+		for runEnd < len(result) && result[runEnd].Library != "" {
+			runEnd++
+		}
+
+		slices.SortStableFunc(result[runStart:runEnd], func(a PragmaLine, b PragmaLine) int {
+			return strings.Compare(strings.ToLower(a.Library), strings.ToLower(b.Library))
+		})
+
+		runStart = runEnd + 1
+	}
+
+	ranges := []TokenRange{}
+
+	for _, line := range result {
+		ranges = append(ranges, line.TokenRange)
+	}
+
+	f.replaceLines(lines[0].Start, lines[len(lines)-1].End, ranges)
+}
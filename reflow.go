@@ -0,0 +1,190 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// List items such as - item, * item, 1. item or 2) item
+var bulletPrefix = regexp.MustCompile(`^([-*+]|[0-9]+[.)])\s+`)
+
+// The // of a line comment, with the / or ! of Doxygen comments
+var lineCommentMarker = regexp.MustCompile(`^//[/!]?`)
+
+// Whether a line of comment text starts a paragraph of its own: a list item, or a tag
+// such as @param or \return
+func startsParagraph(text string) bool {
+	return bulletPrefix.MatchString(text+" ") || strings.HasPrefix(text, "@") || strings.HasPrefix(text, `\`)
+}
+
+// Joins words into lines of at most width columns. The first line starts with first,
+// as the marker of a list item, and the next ones with hanging. A word longer than a
+// line, such as a URL, is never split, nor is a line started with a word that would
+// start a new paragraph.
+func wrapWords(first string, hanging string, words []string, width int) []string {
+	result := []string{}
+	line := first
+	isEmpty := true
+
+	for _, word := range words {
+		switch {
+		case isEmpty:
+			line += word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width && !startsParagraph(word):
+			result = append(result, line)
+			line = hanging + word
+		default:
+			line += " " + word
+		}
+
+		isEmpty = false
+	}
+
+	return append(result, line)
+}
+
+// Rewraps the paragraphs of the lines of a comment to width columns. Blank lines, list
+// items, tags and lines aligned in columns start new paragraphs, and lines indented
+// more than the others, such as code samples, are kept as they are, along with rules
+// and tables. The lines are returned without their common indentation.
+func reflowLines(lines []string, width int) []string {
+	base := -1
+
+	for _, line := range lines {
+		if text := strings.TrimLeft(line, " \t"); strings.TrimSpace(text) != "" {
+			if indentation := len(line) - len(text); base < 0 || indentation < base {
+				base = indentation
+			}
+		}
+	}
+
+	result := []string{}
+	first, hanging, words := "", "", []string(nil)
+
+	flush := func() {
+		if words != nil {
+			result = append(result, wrapWords(first, hanging, words, width)...)
+		}
+
+		first, hanging, words = "", "", nil
+	}
+
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			result = append(result, "")
+			continue
+		}
+
+		line = line[base:]
+		text := strings.TrimLeft(line, " \t")
+		indentation := len(line) - len(text)
+
+		switch {
+		case words != nil && hanging != "" && indentation == len(hanging):
+			words = append(words, strings.Fields(text)...)
+		case indentation > 0 || isBannerLine(text) || alignedText.MatchString(text):
+			flush()
+			result = append(result, line)
+		case bulletPrefix.MatchString(text):
+			flush()
+			first = bulletPrefix.FindString(text)
+			first = strings.TrimRight(first, " \t") + " "
+			hanging = strings.Repeat(" ", utf8.RuneCountInString(first))
+			words = strings.Fields(text[len(bulletPrefix.FindString(text)):])
+		case startsParagraph(text):
+			flush()
+			words = strings.Fields(text)
+		case words == nil:
+			words = strings.Fields(text)
+		default:
+			words = append(words, strings.Fields(text)...)
+		}
+	}
+
+	flush()
+
+	return result
+}
+
+// Whether the token at index is a // comment on a line of its own
+func (f *Formatter) isCommentLine(index int) bool {
+	previous := f.tokenAt(index - 1)
+
+	return f.tokenAt(index).isSingleLineComment() && (previous.hasUnescapedLines() || previous.isAbsent())
+}
+
+// Whether the token at index is a // comment that continues the one on the line before
+func (f *Formatter) continuesCommentLines(index int) bool {
+	previous := f.tokenAt(index - 1)
+
+	return f.isCommentLine(index) && f.isCommentLine(index-1) &&
+		previous.Whitespace.NewLines == 1 && !previous.hasEscapedLines() &&
+		lineCommentMarker.FindString(previous.Content) == lineCommentMarker.FindString(f.token().Content)
+}
+
+// Joins and rewraps the // comments on consecutive lines that start at the current
+// token. Rewrapping them again gives the same lines, so that the formatter can go back
+// to an earlier token when it wraps a line.
+func (f *Formatter) reflowCommentLines() {
+	if !f.reflowsComments() || f.Node().isDirective() ||
+		f.continuesCommentLines(f.TokenIndex) || !f.isCommentLine(f.TokenIndex) {
+		return
+	}
+
+	end := f.TokenIndex + 1
+
+	for f.continuesCommentLines(end) {
+		end++
+	}
+
+	tokens := *f.Tokens
+	marker := lineCommentMarker.FindString(f.token().Content)
+	lines := []string{}
+
+	for _, token := range tokens[f.TokenIndex:end] {
+		lines = append(lines, token.Content[len(marker):])
+	}
+
+	block := []Token{}
+
+	for _, line := range reflowLines(lines, f.Options.ColumnLimit-f.OutputColumn-len(marker)-1) {
+		token := tokens[f.TokenIndex]
+		token.Content = strings.TrimRight(marker+" "+line, " ")
+		token.Whitespace = Whitespace{NewLines: 1, HasUnescapedLines: true}
+		block = append(block, token)
+	}
+
+	block[len(block)-1].Whitespace = tokens[end-1].Whitespace
+	*f.Tokens = slices.Replace(tokens, f.TokenIndex, end, block...)
+	f.replaceLayoutTokens(f.TokenIndex, end-f.TokenIndex, len(block), false)
+}
+
+// Rewraps the lines of a starred comment between its /** and */ lines, and returns
+// them with their *
+func (f *Formatter) reflowStarredLines(lines []string, column int) []string {
+	opening := strings.TrimSpace(lines[0])
+
+	if len(lines) < 3 || strings.TrimSpace(lines[len(lines)-1]) != "*/" ||
+		(opening != "/*" && opening != "/**" && opening != "/*!") {
+		return lines
+	}
+
+	body := []string{}
+
+	for _, line := range lines[1 : len(lines)-1] {
+		body = append(body, strings.TrimPrefix(strings.TrimLeft(line, " \t"), "*"))
+	}
+
+	result := []string{lines[0]}
+
+	for _, line := range reflowLines(body, f.Options.ColumnLimit-column-len(" * ")) {
+		result = append(result, strings.TrimRight("* "+line, " "))
+	}
+
+	return append(result, lines[len(lines)-1])
+}
@@ -0,0 +1,138 @@
+package main
+
+import "fmt"
+
+type LabelIndent int
+
+const (
+	LabelIndentNone LabelIndent = iota
+	LabelIndentOutdent
+	LabelIndentFlush
+)
+
+type LabelIndentName struct {
+	Name        string
+	LabelIndent LabelIndent
+}
+
+var labelIndentNames = [...]LabelIndentName{
+	{"none", LabelIndentNone},
+	{"outdent", LabelIndentOutdent},
+	{"flush", LabelIndentFlush},
+}
+
+func parseLabelIndent(name string) (LabelIndent, error) {
+	for _, n := range labelIndentNames {
+		if n.Name == name {
+			return n.LabelIndent, nil
+		}
+	}
+
+	return LabelIndentNone, fmt.Errorf("invalid label indentation: %s", name)
+}
+
+func (l LabelIndent) String() string {
+	for _, n := range labelIndentNames {
+		if n.LabelIndent == l {
+			return n.Name
+		}
+	}
+
+	panic(fmt.Sprintf("Unexpected label indentation %d", l))
+}
+
+func (f *Formatter) isSwitchBodyStart() bool {
+	return f.token().isLeftBrace() &&
+		f.previousToken().isRightParenthesis() &&
+		f.parenthesisOwner(f.TokenIndex-1).isSwitch()
+}
+
+func (f *Formatter) isInsideSwitchBody() bool {
+	return f.Node().isSwitch() && f.isTopLevelInNode()
+}
+
+func (f *Formatter) isCaseLabelStart() bool {
+	return f.isInsideSwitchBody() && (f.token().isCase() || f.token().isDefault())
+}
+
+func (f *Formatter) isCaseLabelEnd() bool {
+	return f.isInsideSwitchBody() && f.Node().InCaseLabel && f.token().isColon()
+}
+
+func (f *Formatter) startsCaseBody() bool {
+	return f.isCaseLabelEnd() && !f.nextToken().isLeftBrace()
+}
+
+func (f *Formatter) afterCaseLabel() bool {
+	return f.isInsideSwitchBody() &&
+		f.Node().CaseLabelEnd == f.TokenIndex &&
+		!f.nextToken().isLeftBrace()
+}
+
+func (f *Formatter) endsCaseBody() bool {
+	return f.isInsideSwitchBody() &&
+		f.Node().InCaseBody &&
+		(f.nextToken().isCase() || f.nextToken().isDefault() || f.nextToken().isRightBrace())
+}
+
+// Whether the current token ends the block of a case that more statements follow, as
+// the } of case 0: { ... } break;
+func (f *Formatter) endsCaseBlock() bool {
+	return f.isInsideSwitchBody() &&
+		f.afterEndOfBlock() &&
+		f.LastPop.isBlock() &&
+		f.LastPop.FirstToken == f.Node().CaseLabelEnd+1 &&
+		!f.nextToken().isCase() && !f.nextToken().isDefault() && !f.nextToken().isRightBrace()
+}
+
+func (f *Formatter) updateCaseLabels() {
+	if f.isCaseLabelStart() {
+		f.Node().InCaseLabel = true
+	}
+
+	if f.startsCaseBody() {
+		f.Indent++
+		f.Node().InCaseBody = true
+	}
+
+	if f.endsCaseBlock() {
+		f.Indent++
+		f.Node().InCaseBody = true
+	}
+
+	if f.isCaseLabelEnd() {
+		f.Node().InCaseLabel = false
+		f.Node().CaseLabelEnd = f.TokenIndex
+	}
+
+	if f.endsCaseBody() {
+		f.Indent--
+		f.Node().InCaseBody = false
+	}
+}
+
+func (f *Formatter) isGotoLabel(index int) bool {
+	previous := f.tokenAt(index - 1)
+
+	return f.Node().isBlock() &&
+		f.OpenParenthesis == f.Node().InitialParenthesis &&
+		f.tokenAt(index).isIdentifier() &&
+		f.tokenAt(index+1).isColon() &&
+		(previous.isSemicolon() || previous.isLeftBrace() || previous.isRightBrace() ||
+			previous.isColon() || previous.isComment())
+}
+
+func (f *Formatter) isGotoLabelEnd() bool {
+	return f.token().isColon() && f.isGotoLabel(f.TokenIndex-1)
+}
+
+func (f *Formatter) labelIndent(indent int) int {
+	switch f.Options.GotoLabelIndent {
+	case LabelIndentOutdent:
+		return max(indent-1, 0)
+	case LabelIndentFlush:
+		return 0
+	default:
+		return indent
+	}
+}
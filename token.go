@@ -17,6 +17,8 @@ type Token struct {
 	ConstantType    ConstantType
 	Line            int
 	Column          int
+	Inserted        bool
+	Split           bool
 }
 
 type TokenType uint32
@@ -227,7 +229,7 @@ func parseToken(input string) Token {
 		return token
 	}
 
-	if isDoubleQuote(r) || strings.HasPrefix(input, "L\"") {
+	if _, isString := stringPrefix(input); isString {
 		return parseString(input)
 	}
 
@@ -358,12 +360,8 @@ func parseIdentifierOrKeyword(text string) Token {
 }
 
 func parseString(text string) Token {
-	var tokenSize int
-	if text[0] == 'L' {
-		tokenSize = 2
-	} else {
-		tokenSize = 1
-	}
+	prefix, _ := stringPrefix(text)
+	tokenSize := len(prefix) + 1
 
 	next := text[tokenSize:]
 
@@ -555,9 +553,17 @@ func tryParseDirective(s string) (Token, bool) {
 		{"#extension", DirectiveTypeExtension},
 	}
 
+	if !strings.HasPrefix(s, "#") {
+		return Token{}, false
+	}
+
+	spaces := len(s[1:]) - len(strings.TrimLeft(s[1:], " \t"))
+	name := "#" + s[1+spaces:]
+
 	for _, directive := range directives {
-		if strings.HasPrefix(s, directive.Name) {
-			return Token{Type: TokenTypeDirective, Content: directive.Name, DirectiveType: directive.DirectiveType}, true
+		if strings.HasPrefix(name, directive.Name) {
+			content := s[:len(directive.Name)+spaces]
+			return Token{Type: TokenTypeDirective, Content: content, DirectiveType: directive.DirectiveType}, true
 		}
 	}
 
@@ -778,12 +784,17 @@ func (t Token) isMultilineComment() bool {
 	return t.Type == TokenTypeMultilineComment
 }
 
+// Whether the token is a /* */ comment that does not span lines
+func (t Token) isOneLineComment() bool {
+	return t.isMultilineComment() && !strings.Contains(t.Content, "\n")
+}
+
 func (t Token) isAbsent() bool {
 	return t.Type == TokenTypeNone
 }
 
 func (t Token) isIncludeDirective() bool {
-	return t.isDirective() && t.Content == "#include"
+	return t.isDirective() && t.DirectiveType == DirectiveTypeInclude
 }
 
 func (t Token) isPunctuation() bool {
@@ -795,7 +806,7 @@ func (t Token) isEquals() bool {
 }
 
 func (t Token) isPragmaDirective() bool {
-	return t.isDirective() && t.Content == "#pragma"
+	return t.isDirective() && t.DirectiveType == DirectiveTypePragma
 }
 
 func (t Token) isLeftBracesBracketsOrParenthesis() bool {
@@ -887,6 +898,10 @@ func (t Token) isAssignment() bool {
 	return t.Type == TokenTypePunctuation && slices.Contains(assignmentOps, t.PunctuationType)
 }
 
+func (t Token) isPlainAssignment() bool {
+	return t.Type == TokenTypePunctuation && t.PunctuationType == PunctuationTypeAssignment
+}
+
 func (t Token) isComma() bool {
 	return t.Type == TokenTypePunctuation && t.PunctuationType == PunctuationTypeComma
 }
@@ -944,6 +959,38 @@ func (t Token) isLessThanSign() bool {
 	return t.Type == TokenTypePunctuation && t.PunctuationType == PunctuationTypeLessThan
 }
 
+func (t Token) isString() bool {
+	return t.Type == TokenTypeConstant && t.ConstantType == ConstantTypeString
+}
+
+func (t Token) isExtern() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeExtern
+}
+
+func (t Token) isSwitch() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeSwitch
+}
+
+func (t Token) isCase() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeCase
+}
+
+func (t Token) isDefault() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeDefault
+}
+
+func (t Token) isIf() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeIf
+}
+
+func (t Token) isElse() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeElse
+}
+
+func (t Token) isWhile() bool {
+	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeWhile
+}
+
 func (t Token) isDo() bool {
 	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeDo
 }
@@ -952,6 +999,20 @@ func (t Token) isFor() bool {
 	return t.Type == TokenTypeKeyword && t.KeywordType == KeywordTypeFor
 }
 
+func (t Token) isConditionalDirective() bool {
+	return t.isDirective() &&
+		(t.DirectiveType == DirectiveTypeIf ||
+			t.DirectiveType == DirectiveTypeIfdef ||
+			t.DirectiveType == DirectiveTypeIfndef ||
+			t.DirectiveType == DirectiveTypeElif ||
+			t.DirectiveType == DirectiveTypeElse ||
+			t.DirectiveType == DirectiveTypeEndif)
+}
+
+func (t Token) directiveName() string {
+	return "#" + strings.TrimLeft(t.Content[1:], " \t")
+}
+
 func (t Token) hasEscapedLines() bool {
 	return t.Whitespace.HasEscapedLines
 }
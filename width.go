@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A LongLine is a line of the output that goes past the column limit, with the type of
+// the node the formatter was in when the line crossed it
+type LongLine struct {
+	Line     int
+	NodeType NodeType
+}
+
+// Describes a node type in diagnostics
+func (t NodeType) description() string {
+	switch t {
+	case NodeTypeTopLevel:
+		return "top level"
+	case NodeTypeDirective:
+		return "directive"
+	case NodeTypeFuncOrMacroCall:
+		return "function or macro call"
+	case NodeTypeFuncOrMacroDef:
+		return "function or macro definition"
+	case NodeTypeBlock:
+		return "block"
+	case NodeTypeInitializerList:
+		return "initializer list"
+	case NodeTypeStructOrUnion:
+		return "struct or union"
+	case NodeTypeEnum:
+		return "enum"
+	case NodeTypeForLoopParenthesis:
+		return "for loop header"
+	case NodeTypeLinkageSpecification:
+		return "linkage specification"
+	case NodeTypeSwitch:
+		return "switch"
+	case NodeTypeImplicitBlock:
+		return "statement"
+	default:
+		return t.String()
+	}
+}
+
+// Records the line of the current token if it goes past the column limit. Lines
+// recorded before the formatter goes back to wrap a line are forgotten with the rest
+// of its state.
+func (f *Formatter) recordLongLine() {
+	if f.Options.ColumnLimit == 0 || f.OutputColumn <= f.Options.ColumnLimit {
+		return
+	}
+
+	if len(f.LongLines) == 0 || f.LongLines[len(f.LongLines)-1].Line != f.OutputLine {
+		f.LongLines = append(f.LongLines, LongLine{f.OutputLine, f.Node().Type})
+	}
+}
+
+// Returns a warning for each line of the output that goes past the column limit. Lines
+// that only go past it once aligned have no node recorded.
+func longLineWarnings(output string, longLines []LongLine, options Options) []error {
+	if options.ColumnLimit == 0 {
+		return nil
+	}
+
+	warnings := []error{}
+
+	for i, line := range strings.Split(output, options.EndOfLine.newLine()) {
+		width := columnWidth(line, 0, options.TabWidth)
+
+		if width <= options.ColumnLimit {
+			continue
+		}
+
+		cause := "alignment"
+
+		for _, longLine := range longLines {
+			if longLine.Line == i {
+				cause = longLine.NodeType.description()
+			}
+		}
+
+		warnings = append(warnings,
+			fmt.Errorf("%d: line of %d columns exceeds the limit of %d (%s)", i+1, width, options.ColumnLimit, cause))
+	}
+
+	return warnings
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A small YAML reader, for the subset used by configuration files: block mappings and
+// sequences, flow sequences and mappings of scalars, quoted scalars, comments and
+// multiple documents. Anchors, tags and multi-line scalars are not supported.
+
+type YamlKind int
+
+const (
+	YamlKindScalar YamlKind = iota
+	YamlKindMapping
+	YamlKindSequence
+)
+
+type YamlNode struct {
+	Kind     YamlKind
+	Value    string
+	Keys     []string
+	Children []*YamlNode
+	Line     int
+}
+
+type YamlLine struct {
+	Indent int
+	Text   string
+	Number int
+}
+
+type YamlParser struct {
+	Lines []YamlLine
+	Index int
+}
+
+func (n *YamlNode) get(key string) *YamlNode {
+	if n == nil || n.Kind != YamlKindMapping {
+		return nil
+	}
+
+	for i, k := range n.Keys {
+		if k == key {
+			return n.Children[i]
+		}
+	}
+
+	return nil
+}
+
+func parseYaml(text string) ([]*YamlNode, error) {
+	documents := [][]YamlLine{{}}
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(stripYamlComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+
+		if len(trimmed) == 0 || strings.HasPrefix(line, "%") {
+			continue
+		}
+
+		if line == "---" || strings.HasPrefix(line, "--- ") {
+			documents = append(documents, []YamlLine{})
+			continue
+		}
+
+		if line == "..." {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("%d: tabs are not allowed in indentation", i+1)
+		}
+
+		last := &documents[len(documents)-1]
+		*last = append(*last, YamlLine{Indent: len(line) - len(trimmed), Text: trimmed, Number: i + 1})
+	}
+
+	result := []*YamlNode{}
+
+	for _, lines := range documents {
+		if len(lines) == 0 {
+			continue
+		}
+
+		parser := YamlParser{Lines: lines}
+		node, err := parser.parseBlock(lines[0].Indent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if parser.Index < len(lines) {
+			return nil, fmt.Errorf("%d: unexpected indentation", lines[parser.Index].Number)
+		}
+
+		result = append(result, node)
+	}
+
+	return result, nil
+}
+
+func stripYamlComment(line string) string {
+	quote := rune(0)
+
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func (p *YamlParser) line() YamlLine {
+	return p.Lines[p.Index]
+}
+
+func (p *YamlParser) atBlock(indent int) bool {
+	return p.Index < len(p.Lines) && p.line().Indent == indent
+}
+
+func (p *YamlParser) parseBlock(indent int) (*YamlNode, error) {
+	if isYamlSequenceItem(p.line().Text) {
+		return p.parseSequence(indent)
+	}
+
+	return p.parseMapping(indent)
+}
+
+func isYamlSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *YamlParser) parseSequence(indent int) (*YamlNode, error) {
+	result := &YamlNode{Kind: YamlKindSequence, Line: p.line().Number}
+
+	for p.atBlock(indent) && isYamlSequenceItem(p.line().Text) {
+		line := p.line()
+		item := strings.TrimLeft(strings.TrimPrefix(line.Text, "-"), " ")
+
+		if len(item) == 0 {
+			p.Index++
+
+			if p.Index >= len(p.Lines) || p.line().Indent <= indent {
+				result.Children = append(result.Children, &YamlNode{Kind: YamlKindScalar, Line: line.Number})
+				continue
+			}
+
+			child, err := p.parseBlock(p.line().Indent)
+			if err != nil {
+				return nil, err
+			}
+			result.Children = append(result.Children, child)
+			continue
+		}
+
+		// The item is parsed as a block indented by the dash and its spaces
+		p.Lines[p.Index] = YamlLine{
+			Indent: line.Indent + len(line.Text) - len(item),
+			Text:   item,
+			Number: line.Number,
+		}
+
+		child, err := p.parseItem(p.line().Indent)
+		if err != nil {
+			return nil, err
+		}
+		result.Children = append(result.Children, child)
+	}
+
+	return result, nil
+}
+
+func (p *YamlParser) parseItem(indent int) (*YamlNode, error) {
+	_, _, isKey := splitYamlKey(p.line().Text)
+
+	if isKey || isYamlSequenceItem(p.line().Text) {
+		return p.parseBlock(indent)
+	}
+
+	line := p.line()
+	p.Index++
+
+	return parseYamlValue(line.Text, line.Number)
+}
+
+func (p *YamlParser) parseMapping(indent int) (*YamlNode, error) {
+	result := &YamlNode{Kind: YamlKindMapping, Line: p.line().Number}
+
+	for p.atBlock(indent) {
+		line := p.line()
+		key, value, isKey := splitYamlKey(line.Text)
+
+		if !isKey {
+			return nil, fmt.Errorf("%d: expected key: value", line.Number)
+		}
+
+		if len(value) > 0 {
+			child, err := parseYamlValue(value, line.Number)
+			if err != nil {
+				return nil, err
+			}
+			result.Keys = append(result.Keys, key)
+			result.Children = append(result.Children, child)
+			p.Index++
+			continue
+		}
+
+		p.Index++
+
+		// Sequences can be indented like the key they belong to
+		nested := p.Index < len(p.Lines) &&
+			(p.line().Indent > indent || (p.line().Indent == indent && isYamlSequenceItem(p.line().Text)))
+
+		child := &YamlNode{Kind: YamlKindScalar, Line: line.Number}
+
+		if nested {
+			var err error
+			child, err = p.parseBlock(p.line().Indent)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result.Keys = append(result.Keys, key)
+		result.Children = append(result.Children, child)
+	}
+
+	return result, nil
+}
+
+// Splits key: value, where the key can be quoted
+func splitYamlKey(text string) (string, string, bool) {
+	end := 0
+
+	if strings.HasPrefix(text, "'") || strings.HasPrefix(text, "\"") {
+		closing := strings.IndexByte(text[1:], text[0])
+		if closing < 0 {
+			return "", "", false
+		}
+		end = closing + 2
+	}
+
+	for i := end; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key, err := parseYamlScalar(strings.TrimSpace(text[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+
+		if text[i] == '[' || text[i] == '{' {
+			return "", "", false
+		}
+	}
+
+	return "", "", false
+}
+
+func parseYamlValue(text string, line int) (*YamlNode, error) {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return parseYamlFlow(text, line)
+	}
+
+	value, err := parseYamlScalar(text)
+
+	if err != nil {
+		return nil, fmt.Errorf("%d: %s", line, err)
+	}
+
+	return &YamlNode{Kind: YamlKindScalar, Value: value, Line: line}, nil
+}
+
+func parseYamlScalar(text string) (string, error) {
+	if len(text) == 0 {
+		return "", nil
+	}
+
+	switch text[0] {
+	case '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' {
+			return "", fmt.Errorf("unterminated string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case '"':
+		if len(text) < 2 || text[len(text)-1] != '"' {
+			return "", fmt.Errorf("unterminated string %s", text)
+		}
+		replacer := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\t`, "\t")
+		return replacer.Replace(text[1 : len(text)-1]), nil
+	default:
+		return text, nil
+	}
+}
+
+// Parses [a, b] and {a: b, c: d}, whose elements are scalars
+func parseYamlFlow(text string, line int) (*YamlNode, error) {
+	closing := map[byte]byte{'[': ']', '{': '}'}[text[0]]
+
+	if text[len(text)-1] != closing {
+		return nil, fmt.Errorf("%d: unterminated flow collection %s", line, text)
+	}
+
+	elements := splitYamlFlow(text[1 : len(text)-1])
+
+	if text[0] == '[' {
+		result := &YamlNode{Kind: YamlKindSequence, Line: line}
+
+		for _, element := range elements {
+			child, err := parseYamlValue(element, line)
+			if err != nil {
+				return nil, err
+			}
+			result.Children = append(result.Children, child)
+		}
+
+		return result, nil
+	}
+
+	result := &YamlNode{Kind: YamlKindMapping, Line: line}
+
+	for _, element := range elements {
+		key, value, isKey := splitYamlKey(element)
+
+		if !isKey {
+			return nil, fmt.Errorf("%d: expected key: value in %s", line, text)
+		}
+
+		child, err := parseYamlValue(value, line)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Keys = append(result.Keys, key)
+		result.Children = append(result.Children, child)
+	}
+
+	return result, nil
+}
+
+func splitYamlFlow(text string) []string {
+	result := []string{}
+	quote := byte(0)
+	start := 0
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quote != 0:
+			if text[i] == quote {
+				quote = 0
+			}
+		case text[i] == '\'' || text[i] == '"':
+			quote = text[i]
+		case text[i] == ',':
+			result = append(result, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+
+	last := strings.TrimSpace(text[start:])
+
+	if len(last) > 0 {
+		result = append(result, last)
+	}
+
+	return result
+}